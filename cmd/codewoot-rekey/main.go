@@ -0,0 +1,72 @@
+// Command codewoot-rekey re-encrypts every stored session token with the
+// primary key from ENCRYPTION_KEYS. Put the new key first and keep the old
+// ones listed after it until this has run, then they can be removed.
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/sdrvirtual/codewoot/internal/config"
+	"github.com/sdrvirtual/codewoot/internal/db"
+)
+
+func main() {
+	ctx := context.Background()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	keys := cfg.Encryption.Keys
+	if !keys.Enabled() {
+		log.Fatalln("ENCRYPTION_KEYS is not set")
+	}
+
+	conn, err := pgx.Connect(ctx, cfg.Database.URL)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer conn.Close(ctx)
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Raw queries on purpose, the values are rotated as stored
+	q := db.New(tx)
+	sessions, err := q.ListSessions(ctx)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	rotated := 0
+	for _, s := range sessions {
+		codechatToken, codechatChanged, err := keys.Rotate(s.CodechatInstcanceToken)
+		if err != nil {
+			log.Fatalf("session %s: codechat token: %v", s.SessionID.String(), err)
+		}
+		chatwootToken, chatwootChanged, err := keys.Rotate(s.ChatwootToken)
+		if err != nil {
+			log.Fatalf("session %s: chatwoot token: %v", s.SessionID.String(), err)
+		}
+		if !codechatChanged && !chatwootChanged {
+			continue
+		}
+		if err := q.UpdateSessionTokens(ctx, db.UpdateSessionTokensParams{
+			ID:                     s.ID,
+			CodechatInstcanceToken: codechatToken,
+			ChatwootToken:          chatwootToken,
+		}); err != nil {
+			log.Fatalf("session %s: %v", s.SessionID.String(), err)
+		}
+		rotated++
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("Re-encrypted %d of %d sessions with key %q", rotated, len(sessions), keys.PrimaryKeyID())
+}
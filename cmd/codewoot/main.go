@@ -3,19 +3,29 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
+	"net/http"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/pressly/goose/v3"
 	"github.com/sdrvirtual/codewoot/internal/config"
+	_ "github.com/sdrvirtual/codewoot/internal/db/migrations"
+	"github.com/sdrvirtual/codewoot/internal/secrets"
 	"github.com/sdrvirtual/codewoot/internal/server"
+	"github.com/sdrvirtual/codewoot/internal/services"
 )
 
 func main() {
 	ctx := context.Background()
-	cfg, _ := config.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalln(err)
+	}
 	dbCfg, err := pgxpool.ParseConfig(cfg.Database.URL)
 	if err != nil {
 		log.Fatalln(err)
@@ -29,22 +39,57 @@ func main() {
 	if err != nil {
 		log.Fatalln(err)
 	}
-	defer pool.Close()
 
 	db, err := sql.Open("pgx", cfg.Database.URL)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	if err := goose.Up(db, "internal/db/migrations"); err != nil {
+	migrateCtx := secrets.NewContext(ctx, cfg.Encryption.Keys)
+	if err := goose.UpContext(migrateCtx, db, "internal/db/migrations"); err != nil {
 		log.Fatalln(err)
 	}
 	db.Close()
 
-	srv := server.New(cfg, pool)
-	defer srv.Close()
+	broken, err := services.ValidateSessions(ctx, cfg, pool)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for session, err := range broken {
+		log.Printf("session %s is misconfigured: %v", session, err)
+	}
+
+	workers := services.NewWorkers()
+	srv := server.New(cfg, pool, workers)
+
+	stop, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
 
-	log.Printf("Starting server on %s:%s", cfg.Server.Host, cfg.Server.Port)
-	if err := srv.ListenAndServe(); err != nil {
-		log.Fatal(err)
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Starting server on %s:%s", cfg.Server.Host, cfg.Server.Port)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	case <-stop.Done():
+		log.Printf("Shutting down, waiting up to %s for in-flight requests", cfg.Server.ShutdownTimeout)
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(ctx, cfg.Server.ShutdownTimeout)
+	defer cancelShutdown()
+
+	// Stop accepting requests and wait for the in-flight webhooks to finish
+	// before stopping the workers and closing the pool they depend on.
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("error shutting down server: %v", err)
+	}
+	if err := workers.Shutdown(shutdownCtx); err != nil {
+		log.Printf("error stopping background workers: %v", err)
 	}
+	pool.Close()
+	log.Printf("Server stopped")
 }
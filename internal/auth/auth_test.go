@@ -0,0 +1,90 @@
+package auth
+
+import "testing"
+
+func TestGenerateAndParseKey(t *testing.T) {
+	key, prefix, hash, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotPrefix, secret, err := ParseKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotPrefix != prefix {
+		t.Errorf("prefix = %q, want %q", gotPrefix, prefix)
+	}
+	if !VerifySecret(secret, hash) {
+		t.Error("secret doesn't match its hash")
+	}
+	if VerifySecret(secret+"x", hash) {
+		t.Error("wrong secret matched the hash")
+	}
+}
+
+func TestParseKeyMalformed(t *testing.T) {
+	for _, key := range []string{"", "secret", "cwk_", "cwk_abc", "cwk__abc", "cwk_abc_"} {
+		if _, _, err := ParseKey(key); err != ErrMalformedKey {
+			t.Errorf("ParseKey(%q) err = %v, want ErrMalformedKey", key, err)
+		}
+	}
+}
+
+func TestPrincipalScopes(t *testing.T) {
+	const session = "0b9f1c2e-6a4d-4c8e-9f3a-2d1b7e5c4a90"
+	tests := []struct {
+		name      string
+		principal Principal
+		sessionID string
+		accountID int32
+		want      bool
+	}{
+		{"admin", Principal{Admin: true, SessionIDs: []string{"other"}}, session, 1, true},
+		{"unscoped", Principal{}, session, 1, true},
+		{"session scope", Principal{SessionIDs: []string{session}}, session, 1, true},
+		{"other session", Principal{SessionIDs: []string{"other"}}, session, 1, false},
+		{"account scope", Principal{AccountIDs: []int32{1}}, session, 1, true},
+		{"other account", Principal{AccountIDs: []int32{2}}, session, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.principal.CanAccess(tt.sessionID, tt.accountID); got != tt.want {
+				t.Errorf("CanAccess = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrincipalAccountScope(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal Principal
+		want      bool
+	}{
+		{"admin", Principal{Admin: true, AccountIDs: []int32{2}}, true},
+		{"unscoped", Principal{}, true},
+		{"account scope", Principal{AccountIDs: []int32{1}}, true},
+		{"other account", Principal{AccountIDs: []int32{2}}, false},
+		{"session scope only", Principal{SessionIDs: []string{"0b9f1c2e-6a4d-4c8e-9f3a-2d1b7e5c4a90"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.principal.CanAccessAccount(1); got != tt.want {
+				t.Errorf("CanAccessAccount = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrincipalPermissions(t *testing.T) {
+	p := Principal{Permissions: []Permission{PermReadSessions}}
+	if !p.Can(PermReadSessions) {
+		t.Error("expected sessions:read")
+	}
+	if p.Can(PermManageSessions) {
+		t.Error("unexpected sessions:write")
+	}
+	if !(&Principal{Admin: true}).Can(PermSendMessages) {
+		t.Error("admin should have every permission")
+	}
+}
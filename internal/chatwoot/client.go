@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -60,6 +61,69 @@ func New(baseURL, token string, accountID int, opts ...Option) (*Client, error)
 	return c, nil
 }
 
+type APIError struct {
+	StatusCode int
+	Path       string
+	Body       string
+	Message    string
+	Errors     []string
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("chatwoot API error: status=%d path=%s message=%s", e.StatusCode, e.Path, e.Message)
+	}
+	return fmt.Sprintf("chatwoot API error: status=%d path=%s body=%s", e.StatusCode, e.Path, e.Body)
+}
+
+func newAPIError(res *http.Response, body []byte) *APIError {
+	e := &APIError{
+		StatusCode: res.StatusCode,
+		Path:       res.Request.URL.Path,
+		Body:       string(body),
+	}
+	// Chatwoot isn't consistent about error bodies: depending on the
+	// controller it answers {"error": "..."}, {"message": "..."} or
+	// {"errors": [...]}, where errors may hold strings or objects.
+	var out struct {
+		Error   string            `json:"error"`
+		Message string            `json:"message"`
+		Errors  []json.RawMessage `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return e
+	}
+	for _, raw := range out.Errors {
+		var msg string
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			msg = string(raw)
+		}
+		e.Errors = append(e.Errors, msg)
+	}
+	switch {
+	case out.Message != "":
+		e.Message = out.Message
+	case out.Error != "":
+		e.Message = out.Error
+	case len(e.Errors) > 0:
+		e.Message = strings.Join(e.Errors, "; ")
+	}
+	return e
+}
+
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized)
+}
+
+func hasStatus(err error, status int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
 func (c *Client) newRequest(ctx context.Context, method, p string, body any) (*http.Request, error) {
 	u := *c.baseURL
 	u.Path = path.Join(c.baseURL.Path, p)
@@ -109,7 +173,7 @@ func (c *Client) do(req *http.Request) (json.RawMessage, error) {
 		return nil, err
 	}
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		return nil, fmt.Errorf("chatwoot API error: status=%d body=%s", res.StatusCode, string(b))
+		return nil, newAPIError(res, b)
 	}
 	return json.RawMessage(b), nil
 }
@@ -0,0 +1,108 @@
+package chatwoot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestClient(t *testing.T, status int, body string) *Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL, "tok", 1)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	return c
+}
+
+func TestDo_APIError(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		wantMessage string
+		wantErrors  []string
+	}{
+		{
+			name:        "error_field",
+			status:      http.StatusUnauthorized,
+			body:        `{"error":"Invalid Access Token"}`,
+			wantMessage: "Invalid Access Token",
+		},
+		{
+			name:        "message_field",
+			status:      http.StatusNotFound,
+			body:        `{"message":"Resource could not be found"}`,
+			wantMessage: "Resource could not be found",
+		},
+		{
+			name:        "errors_list",
+			status:      http.StatusUnprocessableEntity,
+			body:        `{"errors":["Phone number has already been taken","Name is too long"]}`,
+			wantMessage: "Phone number has already been taken; Name is too long",
+			wantErrors:  []string{"Phone number has already been taken", "Name is too long"},
+		},
+		{
+			name:   "not_json",
+			status: http.StatusBadGateway,
+			body:   "bad gateway",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestClient(t, tc.status, tc.body)
+			req, err := c.newRequest(context.Background(), http.MethodGet, "/api/v1/accounts/1/conversations/7", nil)
+			if err != nil {
+				t.Fatalf("newRequest error: %v", err)
+			}
+
+			_, err = c.do(req)
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected *APIError, got %T (%v)", err, err)
+			}
+			if apiErr.StatusCode != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, apiErr.StatusCode)
+			}
+			if apiErr.Path != "/api/v1/accounts/1/conversations/7" {
+				t.Fatalf("unexpected path: %q", apiErr.Path)
+			}
+			if apiErr.Body != tc.body {
+				t.Fatalf("expected body %q, got %q", tc.body, apiErr.Body)
+			}
+			if apiErr.Message != tc.wantMessage {
+				t.Fatalf("expected message %q, got %q", tc.wantMessage, apiErr.Message)
+			}
+			if strings.Join(apiErr.Errors, "|") != strings.Join(tc.wantErrors, "|") {
+				t.Fatalf("expected errors %v, got %v", tc.wantErrors, apiErr.Errors)
+			}
+		})
+	}
+}
+
+func TestIsNotFoundAndIsUnauthorized(t *testing.T) {
+	notFound := fmt.Errorf("wrapped: %w", &APIError{StatusCode: http.StatusNotFound})
+	unauthorized := &APIError{StatusCode: http.StatusUnauthorized}
+
+	if !IsNotFound(notFound) || IsUnauthorized(notFound) {
+		t.Fatalf("expected wrapped 404 to be IsNotFound only")
+	}
+	if !IsUnauthorized(unauthorized) || IsNotFound(unauthorized) {
+		t.Fatalf("expected 401 to be IsUnauthorized only")
+	}
+	if IsNotFound(nil) || IsNotFound(errors.New("boom")) {
+		t.Fatalf("expected non-API errors not to match")
+	}
+}
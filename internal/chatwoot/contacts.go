@@ -5,11 +5,45 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/sdrvirtual/codewoot/internal/dto"
+	"github.com/sdrvirtual/codewoot/internal/utils"
 )
 
+// Bounds the contact search pages read for one number, Chatwoot returns 15
+// contacts per page
+const maxContactSearchPages = 20
+
+// GetContactByPhone returns the contact with exactly this phone number, or
+// nil. Brazilian mobiles match with and without the ninth digit.
 func (c *Client) GetContactByPhone(ctx context.Context, phoneNumber string) (*dto.CWContact, error) {
+	forms := utils.PhoneForms(phoneNumber)
+	if len(forms) == 0 {
+		return nil, fmt.Errorf("phone number is required")
+	}
+	// Search matches substrings, longer numbers ending in the same digits
+	// come back too. Pages are read until the exact number turns up.
+	for _, q := range forms {
+		for page := 1; page <= maxContactSearchPages; page++ {
+			contacts, err := c.searchContacts(ctx, q, page)
+			if err != nil {
+				return nil, err
+			}
+			if len(contacts) == 0 {
+				break
+			}
+			for i := range contacts {
+				if utils.SamePhone(contacts[i].PhoneNumber, q) {
+					return &contacts[i], nil
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (c *Client) searchContacts(ctx context.Context, query string, page int) ([]dto.CWContact, error) {
 	p := fmt.Sprintf("/api/v1/accounts/%d/contacts/search", c.accountID)
 
 	req, err := c.newRequest(ctx, http.MethodGet, p, nil)
@@ -19,7 +53,8 @@ func (c *Client) GetContactByPhone(ctx context.Context, phoneNumber string) (*dt
 	}
 
 	q := req.URL.Query()
-	q.Set("q", phoneNumber)
+	q.Set("q", query)
+	q.Set("page", strconv.Itoa(page))
 	req.URL.RawQuery = q.Encode()
 
 	raw, err := c.do(req)
@@ -32,9 +67,42 @@ func (c *Client) GetContactByPhone(ctx context.Context, phoneNumber string) (*dt
 	if err := json.Unmarshal(raw, &out); err != nil {
 		return nil, fmt.Errorf("decode contact: %w", err)
 	}
+	return out.Payload, nil
+}
 
-	if len(out.Payload) > 0 {
-		return &out.Payload[0], nil
+// GetContactByIdentifier returns the contact with exactly this identifier,
+// or nil.
+func (c *Client) GetContactByIdentifier(ctx context.Context, identifier string) (*dto.CWContact, error) {
+	if identifier == "" {
+		return nil, fmt.Errorf("identifier is required")
+	}
+	p := fmt.Sprintf("/api/v1/accounts/%d/contacts/filter", c.accountID)
+	body := map[string]any{
+		"payload": []map[string]any{{
+			"attribute_key":   "identifier",
+			"filter_operator": "equal_to",
+			"values":          []string{identifier},
+			"query_operator":  nil,
+		}},
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, p, body)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		Payload []dto.CWContact `json:"payload"`
+	}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("decode contact: %w", err)
+	}
+	for i := range out.Payload {
+		if id := out.Payload[i].Identifier; id != nil && *id == identifier {
+			return &out.Payload[i], nil
+		}
 	}
 	return nil, nil
 }
@@ -64,6 +132,35 @@ func (c *Client) CreateContact(ctx context.Context, params CreateContactParams)
 	return out.Payload.Contact, nil
 }
 
+type UpdateContactParams struct {
+	Name       *string `json:"name,omitempty"`
+	Identifier *string `json:"identifier,omitempty"`
+	// Chatwoot downloads the avatar from it in the background
+	AvatarURL *string `json:"avatar_url,omitempty"`
+	// Merged into the existing attributes by Chatwoot
+	AdditionalAttributes map[string]any `json:"additional_attributes,omitempty"`
+}
+
+// UpdateContact changes the fields of the contact that are set in params.
+func (c *Client) UpdateContact(ctx context.Context, contactID int, params UpdateContactParams) (*dto.CWContact, error) {
+	p := fmt.Sprintf("/api/v1/accounts/%d/contacts/%d", c.accountID, contactID)
+	req, err := c.newRequest(ctx, http.MethodPut, p, params)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		Payload *dto.CWContact `json:"payload"`
+	}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("decode contact: %w", err)
+	}
+	return out.Payload, nil
+}
+
 func (c *Client) CreateContactInbox(ctx context.Context, contactID int, params CreateContactInboxParams) (*dto.CWContactInbox, error) {
 	p := fmt.Sprintf("/api/v1/accounts/%d/contacts/%d/contact_inboxes", c.accountID, contactID)
 	req, err := c.newRequest(ctx, http.MethodPost, p, params)
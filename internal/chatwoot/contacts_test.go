@@ -0,0 +1,126 @@
+package chatwoot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetContactByPhone_ExactMatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("page") != "1" {
+			_, _ = w.Write([]byte(`{"payload":[]}`))
+			return
+		}
+		switch r.URL.Query().Get("q") {
+		case "5511988776655", "5531988776655":
+			_, _ = w.Write([]byte(`{"payload":[
+				{"id":1,"name":"5511988776655 Ltd","phone_number":""}
+			]}`))
+		case "551188776655":
+			_, _ = w.Write([]byte(`{"payload":[
+				{"id":2,"name":"Other","phone_number":"+44551188776655"},
+				{"id":3,"name":"Ana","phone_number":"+551188776655"}
+			]}`))
+		default:
+			_, _ = w.Write([]byte(`{"payload":[]}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL, "tok", 1)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	ctt, err := c.GetContactByPhone(context.Background(), "+5511988776655")
+	if err != nil {
+		t.Fatalf("GetContactByPhone() error: %v", err)
+	}
+	if ctt == nil || ctt.ID != 3 {
+		t.Fatalf("got contact %+v, want id 3", ctt)
+	}
+
+	ctt, err = c.GetContactByPhone(context.Background(), "+5531988776655")
+	if err != nil {
+		t.Fatalf("GetContactByPhone() error: %v", err)
+	}
+	if ctt != nil {
+		t.Fatalf("got contact %+v, want none", ctt)
+	}
+}
+
+func TestGetContactByPhone_Pages(t *testing.T) {
+	var pages []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		pages = append(pages, page)
+		w.Header().Set("Content-Type", "application/json")
+		var contacts []map[string]any
+		switch page {
+		case "1":
+			// A full page of longer numbers ending in the same digits
+			for i := 0; i < 15; i++ {
+				contacts = append(contacts, map[string]any{"id": 100 + i, "phone_number": fmt.Sprintf("+%d14155550123", i+1)})
+			}
+		case "2":
+			contacts = append(contacts, map[string]any{"id": 9, "name": "Bob", "phone_number": "+14155550123"})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"payload": contacts})
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL, "tok", 1)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	ctt, err := c.GetContactByPhone(context.Background(), "+14155550123")
+	if err != nil {
+		t.Fatalf("GetContactByPhone() error: %v", err)
+	}
+	if ctt == nil || ctt.ID != 9 {
+		t.Fatalf("got contact %+v, want id 9", ctt)
+	}
+	if len(pages) != 2 {
+		t.Fatalf("read pages %v, want 1 and 2", pages)
+	}
+}
+
+func TestGetContactByIdentifier(t *testing.T) {
+	const jid = "5511988776655@s.whatsapp.net"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/accounts/1/contacts/filter" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var body struct {
+			Payload []struct {
+				AttributeKey string   `json:"attribute_key"`
+				Values       []string `json:"values"`
+			} `json:"payload"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		if len(body.Payload) != 1 || body.Payload[0].AttributeKey != "identifier" || body.Payload[0].Values[0] != jid {
+			t.Errorf("unexpected filter: %+v", body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"payload":[{"id":7,"identifier":"5511988776655@s.whatsapp.net"}]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL, "tok", 1)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	ctt, err := c.GetContactByIdentifier(context.Background(), jid)
+	if err != nil {
+		t.Fatalf("GetContactByIdentifier() error: %v", err)
+	}
+	if ctt == nil || ctt.ID != 7 {
+		t.Fatalf("got contact %+v, want id 7", ctt)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 
 	"github.com/sdrvirtual/codewoot/internal/dto"
 )
@@ -14,6 +16,7 @@ type CreateContactParams struct {
 	Name                 string         `json:"name,omitempty"`
 	Email                string         `json:"email,omitempty"`
 	PhoneNumber          string         `json:"phone_number,omitempty"`
+	Identifier           string         `json:"identifier,omitempty"`
 	Thumbnail            string         `json:"thumbnail,omitempty"`
 	AdditionalAttributes map[string]any `json:"additional_attributes,omitempty"`
 }
@@ -27,7 +30,10 @@ type ConversationRef struct {
 	ID int `json:"id"`
 }
 
-func (c *Client) GetContactConversations(ctx context.Context, contactID int) ([]dto.CWConversation, error) {
+// GetContactConversations returns the latest conversations of the contact,
+// most recently active first. When statuses are given, only conversations
+// in one of them are returned.
+func (c *Client) GetContactConversations(ctx context.Context, contactID int, statuses ...string) ([]dto.CWConversation, error) {
 	p := fmt.Sprintf("/api/v1/accounts/%d/contacts/%d/conversations", c.accountID, contactID)
 	req, err := c.newRequest(ctx, http.MethodGet, p, nil)
 	if err != nil {
@@ -44,11 +50,76 @@ func (c *Client) GetContactConversations(ctx context.Context, contactID int) ([]
 	if err := json.Unmarshal(raw, &out); err != nil {
 		return nil, fmt.Errorf("decode conversation: %w", err)
 	}
+	if len(statuses) == 0 {
+		return out.Payload, nil
+	}
+
+	// The endpoint has no status filter
+	convs := out.Payload[:0]
+	for _, conv := range out.Payload {
+		if slices.Contains(statuses, conv.Status) {
+			convs = append(convs, conv)
+		}
+	}
+	return convs, nil
+}
+
+// ToggleConversationStatus sets the status of the conversation: open,
+// resolved, pending or snoozed.
+func (c *Client) ToggleConversationStatus(ctx context.Context, conversationID int, status string) error {
+	p := fmt.Sprintf("/api/v1/accounts/%d/conversations/%d/toggle_status", c.accountID, conversationID)
+	req, err := c.newRequest(ctx, http.MethodPost, p, map[string]string{"status": status})
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req)
+	return err
+}
+
+type ListConversationsParams struct {
+	InboxID int
+	// open, resolved, pending, snoozed or all. Chatwoot defaults to open.
+	Status string
+	// Starts at 1, Chatwoot returns 25 conversations per page
+	Page int
+}
+
+func (c *Client) ListConversations(ctx context.Context, params ListConversationsParams) ([]dto.CWConversation, error) {
+	p := fmt.Sprintf("/api/v1/accounts/%d/conversations", c.accountID)
+	req, err := c.newRequest(ctx, http.MethodGet, p, nil)
+	if err != nil {
+		return nil, err
+	}
+	q := req.URL.Query()
+	if params.InboxID > 0 {
+		q.Set("inbox_id", strconv.Itoa(params.InboxID))
+	}
+	if params.Status != "" {
+		q.Set("status", params.Status)
+	}
+	if params.Page > 0 {
+		q.Set("page", strconv.Itoa(params.Page))
+	}
+	req.URL.RawQuery = q.Encode()
 
-	return out.Payload, nil
+	raw, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		Data struct {
+			Payload []dto.CWConversation `json:"payload"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("decode conversations: %w", err)
+	}
+	return out.Data.Payload, nil
 }
 
-func (c *Client) CreateConversation(ctx context.Context, sourceID string, inboxID int) (int, error) {
+// CreateConversation starts a conversation for the contact inbox, with the
+// optional customAttributes set.
+func (c *Client) CreateConversation(ctx context.Context, sourceID string, inboxID int, customAttributes map[string]any) (int, error) {
 	if sourceID == "" {
 		return 0, fmt.Errorf("source_id is required")
 	}
@@ -59,6 +130,9 @@ func (c *Client) CreateConversation(ctx context.Context, sourceID string, inboxI
 		"source_id": sourceID,
 		"inbox_id":  inboxID,
 	}
+	if len(customAttributes) > 0 {
+		body["custom_attributes"] = customAttributes
+	}
 	p := fmt.Sprintf("/api/v1/accounts/%d/conversations", c.accountID)
 	req, err := c.newRequest(ctx, http.MethodPost, p, body)
 	if err != nil {
@@ -74,3 +148,15 @@ func (c *Client) CreateConversation(ctx context.Context, sourceID string, inboxI
 	}
 	return ref.ID, nil
 }
+
+// SetConversationCustomAttributes replaces the custom attributes of the
+// conversation with attrs.
+func (c *Client) SetConversationCustomAttributes(ctx context.Context, conversationID int, attrs map[string]any) error {
+	p := fmt.Sprintf("/api/v1/accounts/%d/conversations/%d/custom_attributes", c.accountID, conversationID)
+	req, err := c.newRequest(ctx, http.MethodPost, p, map[string]any{"custom_attributes": attrs})
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req)
+	return err
+}
@@ -0,0 +1,65 @@
+package chatwoot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetContactConversations_FiltersStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/accounts/1/contacts/7/conversations" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"payload":[{"id":3,"status":"resolved"},{"id":2,"status":"open"},{"id":1,"status":"pending"}]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL, "tok", 1)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	all, err := c.GetContactConversations(context.Background(), 7)
+	if err != nil {
+		t.Fatalf("GetContactConversations() error: %v", err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("got %d conversations, want 3", len(all))
+	}
+	active, err := c.GetContactConversations(context.Background(), 7, "open", "pending")
+	if err != nil {
+		t.Fatalf("GetContactConversations() error: %v", err)
+	}
+	if len(active) != 2 || active[0].ID != 2 || active[1].ID != 1 {
+		t.Fatalf("unexpected conversations: %+v", active)
+	}
+}
+
+func TestToggleConversationStatus(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/accounts/1/conversations/9/toggle_status" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"payload":{"success":true,"current_status":"open","conversation_id":9}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL, "tok", 1)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	if err := c.ToggleConversationStatus(context.Background(), 9, "open"); err != nil {
+		t.Fatalf("ToggleConversationStatus() error: %v", err)
+	}
+	if got["status"] != "open" {
+		t.Fatalf("status = %q, want open", got["status"])
+	}
+}
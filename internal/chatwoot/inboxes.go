@@ -0,0 +1,113 @@
+package chatwoot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/sdrvirtual/codewoot/internal/dto"
+)
+
+func (c *Client) GetInbox(ctx context.Context, inboxID int) (*dto.CWInbox, error) {
+	p := fmt.Sprintf("/api/v1/accounts/%d/inboxes/%d", c.accountID, inboxID)
+	req, err := c.newRequest(ctx, http.MethodGet, p, nil)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var out dto.CWInbox
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("decode inbox: %w", err)
+	}
+	return &out, nil
+}
+
+type CreateInboxParams struct {
+	Name    string             `json:"name"`
+	Channel CreateInboxChannel `json:"channel"`
+}
+
+type CreateInboxChannel struct {
+	Type       string `json:"type"`
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+// NewAPIInboxParams returns the params of an API channel inbox that posts
+// its events to webhookURL.
+func NewAPIInboxParams(name, webhookURL string) CreateInboxParams {
+	return CreateInboxParams{
+		Name: name,
+		Channel: CreateInboxChannel{
+			Type:       "api",
+			WebhookURL: webhookURL,
+		},
+	}
+}
+
+func (c *Client) CreateInbox(ctx context.Context, params CreateInboxParams) (*dto.CWInbox, error) {
+	if params.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	p := fmt.Sprintf("/api/v1/accounts/%d/inboxes", c.accountID)
+	req, err := c.newRequest(ctx, http.MethodPost, p, params)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var out dto.CWInbox
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("decode inbox: %w", err)
+	}
+	return &out, nil
+}
+
+// UpdateInboxWebhook points the webhook of an API channel inbox at
+// webhookURL.
+func (c *Client) UpdateInboxWebhook(ctx context.Context, inboxID int, webhookURL string) error {
+	p := fmt.Sprintf("/api/v1/accounts/%d/inboxes/%d", c.accountID, inboxID)
+	var body struct {
+		Channel struct {
+			WebhookURL string `json:"webhook_url"`
+		} `json:"channel"`
+	}
+	body.Channel.WebhookURL = webhookURL
+	req, err := c.newRequest(ctx, http.MethodPatch, p, body)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req)
+	return err
+}
+
+func (c *Client) DeleteInbox(ctx context.Context, inboxID int) error {
+	p := fmt.Sprintf("/api/v1/accounts/%d/inboxes/%d", c.accountID, inboxID)
+	req, err := c.newRequest(ctx, http.MethodDelete, p, nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req)
+	return err
+}
+
+// AddInboxMembers assigns the agents to the inbox, on top of its current
+// members.
+func (c *Client) AddInboxMembers(ctx context.Context, inboxID int, userIDs []int) error {
+	p := fmt.Sprintf("/api/v1/accounts/%d/inbox_members", c.accountID)
+	body := struct {
+		InboxID int   `json:"inbox_id"`
+		UserIDs []int `json:"user_ids"`
+	}{inboxID, userIDs}
+	req, err := c.newRequest(ctx, http.MethodPost, p, body)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req)
+	return err
+}
@@ -0,0 +1,65 @@
+package chatwoot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreateInbox(t *testing.T) {
+	var got CreateInboxParams
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/accounts/1/inboxes" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":42,"name":"Support","channel_type":"Channel::Api"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL, "tok", 1)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	inbox, err := c.CreateInbox(context.Background(), NewAPIInboxParams("Support", "https://bridge/chatwoot/webhook/x"))
+	if err != nil {
+		t.Fatalf("CreateInbox() error: %v", err)
+	}
+	if inbox.ID != 42 {
+		t.Fatalf("inbox.ID = %d, want 42", inbox.ID)
+	}
+	if got.Name != "Support" || got.Channel.Type != "api" || got.Channel.WebhookURL != "https://bridge/chatwoot/webhook/x" {
+		t.Fatalf("unexpected body: %+v", got)
+	}
+}
+
+func TestUpdateInboxWebhook(t *testing.T) {
+	var got map[string]map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/api/v1/accounts/1/inboxes/42" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":42}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL, "tok", 1)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	if err := c.UpdateInboxWebhook(context.Background(), 42, "https://bridge/chatwoot/webhook/x?secret=s"); err != nil {
+		t.Fatalf("UpdateInboxWebhook() error: %v", err)
+	}
+	if len(got) != 1 || len(got["channel"]) != 1 || got["channel"]["webhook_url"] != "https://bridge/chatwoot/webhook/x?secret=s" {
+		t.Fatalf("unexpected body: %+v", got)
+	}
+}
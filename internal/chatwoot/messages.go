@@ -19,7 +19,9 @@ type ChatwootClientMessage struct {
 	MessageType    dto.CWMessageType
 	FileType       string
 	Private        bool
-	Attachment     *dto.FileData
+	// Marks messages that came from WhatsApp, so they aren't sent back
+	SourceID   string
+	Attachment *dto.FileData
 }
 
 func NewChatwootClientMessage() ChatwootClientMessage {
@@ -57,6 +59,26 @@ func (c *Client) CreateMessage(ctx context.Context, message ChatwootClientMessag
 			return nil, err
 		}
 	}
+	if message.Private {
+		fw, err := mw.CreateFormField("private")
+		if err != nil {
+			return nil, err
+		}
+		_, err = fw.Write([]byte("true"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if message.SourceID != "" {
+		fw, err := mw.CreateFormField("source_id")
+		if err != nil {
+			return nil, err
+		}
+		_, err = fw.Write([]byte(message.SourceID))
+		if err != nil {
+			return nil, err
+		}
+	}
 	if message.FileType != "" {
 		fw, err := mw.CreateFormField("file_type")
 		if err != nil {
@@ -104,3 +126,19 @@ func (c *Client) CreateMessage(ctx context.Context, message ChatwootClientMessag
 	}
 	return &out, nil
 }
+
+// UpdateMessageStatus sets the delivery status of a message of an API
+// channel inbox, e.g. "failed" with the reason shown to the agent.
+func (c *Client) UpdateMessageStatus(ctx context.Context, conversationID, messageID int, status, externalError string) error {
+	p := fmt.Sprintf("/api/v1/accounts/%d/conversations/%d/messages/%d", c.accountID, conversationID, messageID)
+	body := map[string]any{"status": status}
+	if externalError != "" {
+		body["external_error"] = externalError
+	}
+	req, err := c.newRequest(ctx, http.MethodPatch, p, body)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req)
+	return err
+}
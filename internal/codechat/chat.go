@@ -2,6 +2,7 @@ package codechat
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"mime"
 	"net/http"
@@ -42,3 +43,30 @@ func (c *Client) GetMediaData(ctx context.Context, message *dto.CodechatData) (*
 
 	return &fileData, nil
 }
+
+type ProfilePictureResponse struct {
+	Wuid              string `json:"wuid"`
+	ProfilePictureURL string `json:"profilePictureUrl"`
+}
+
+// FetchProfilePictureURL returns the URL of the WhatsApp profile picture of
+// number, a phone number or JID. It's empty when the picture is hidden.
+func (c *Client) FetchProfilePictureURL(ctx context.Context, number string) (string, error) {
+	if c.instance == "" {
+		return "", fmt.Errorf("instance is required")
+	}
+	p := "/chat/fetchProfilePictureUrl/" + url.PathEscape(c.instance)
+	req, err := c.newRequest(ctx, http.MethodPost, p, map[string]string{"number": number})
+	if err != nil {
+		return "", err
+	}
+	jr, _, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	var resp ProfilePictureResponse
+	if err := json.Unmarshal(jr, &resp); err != nil {
+		return "", err
+	}
+	return resp.ProfilePictureURL, nil
+}
@@ -0,0 +1,39 @@
+package codechat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchProfilePictureURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/chat/fetchProfilePictureUrl/inst" {
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+		if body["number"] != "5511988776655" {
+			t.Fatalf("number = %q, want 5511988776655", body["number"])
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"wuid":"5511988776655@s.whatsapp.net","profilePictureUrl":"https://pps.whatsapp.net/v/p.jpg"}`))
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, "tok", WithInstanceToken("itok", "inst"))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	u, err := c.FetchProfilePictureURL(context.Background(), "5511988776655")
+	if err != nil {
+		t.Fatalf("FetchProfilePictureURL() error: %v", err)
+	}
+	if u != "https://pps.whatsapp.net/v/p.jpg" {
+		t.Fatalf("url = %q", u)
+	}
+}
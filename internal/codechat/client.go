@@ -70,12 +70,46 @@ func New(base, token string, opts ...Option) (*Client, error) {
 type APIError struct {
 	StatusCode int
 	Body       string
+	// Human readable reason parsed from the body, if any
+	Message string
 }
 
 func (e *APIError) Error() string {
 	return fmt.Sprintf("codechat API error: status=%d body=%s", e.StatusCode, e.Body)
 }
 
+// newAPIError parses Codechat's error bodies, shaped like
+// {"status": 400, "error": "Bad Request", "message": ...} where message is
+// a string or a list of strings or objects.
+func newAPIError(status int, body []byte) *APIError {
+	e := &APIError{StatusCode: status, Body: string(body)}
+	var out struct {
+		Error   string          `json:"error"`
+		Message json.RawMessage `json:"message"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return e
+	}
+	var msg string
+	var msgs []json.RawMessage
+	switch {
+	case json.Unmarshal(out.Message, &msg) == nil && msg != "":
+		e.Message = msg
+	case json.Unmarshal(out.Message, &msgs) == nil && len(msgs) > 0:
+		parts := make([]string, 0, len(msgs))
+		for _, raw := range msgs {
+			if err := json.Unmarshal(raw, &msg); err != nil {
+				msg = string(raw)
+			}
+			parts = append(parts, msg)
+		}
+		e.Message = strings.Join(parts, "; ")
+	default:
+		e.Message = out.Error
+	}
+	return e
+}
+
 func (c *Client) newRequest(ctx context.Context, method, p string, body any) (*http.Request, error) {
 	u := *c.baseURL
 	u.Path = path.Join(c.baseURL.Path, p)
@@ -123,7 +157,7 @@ func (c *Client) do(req *http.Request) (json.RawMessage, *http.Response, error)
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
 		b, _ := io.ReadAll(res.Body)
 		_ = res.Body.Close()
-		return nil, nil, &APIError{StatusCode: res.StatusCode, Body: string(b)}
+		return nil, nil, newAPIError(res.StatusCode, b)
 	}
 
 	ct := res.Header.Get("Content-Type")
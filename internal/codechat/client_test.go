@@ -263,6 +263,24 @@ func TestAPIError_ErrorString(t *testing.T) {
 	}
 }
 
+func TestNewAPIError_Message(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"status":400,"error":"Bad Request","message":["number is not on whatsapp"]}`, "number is not on whatsapp"},
+		{`{"status":400,"error":"Bad Request","message":[{"exists":false,"number":"123"}]}`, `{"exists":false,"number":"123"}`},
+		{`{"status":404,"error":"Not Found","message":"instance not found"}`, "instance not found"},
+		{`{"status":500,"error":"Internal Server Error"}`, "Internal Server Error"},
+		{`boom`, ""},
+	}
+	for _, tt := range tests {
+		if got := newAPIError(400, []byte(tt.body)).Message; got != tt.want {
+			t.Errorf("newAPIError(%s).Message = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
 func TestNewRequest_PathJoinWithLeadingSlash(t *testing.T) {
 	c, err := New("http://example.com/base/", "tok")
 	if err != nil {
@@ -42,6 +42,9 @@ type FetchInstanceResponse struct {
 type ConnectInstanceResponse struct {
 	Count  int    `json:"count"`
 	Base64 string `json:"base64"`
+	Code   string `json:"code"`
+	// Only set when connecting with a phone number
+	PairingCode string `json:"pairingCode"`
 }
 
 func (c *Client) CreateInstance(ctx context.Context, payload CreateInstanceParams) (*CreateInstanceResponse, error) {
@@ -82,6 +85,19 @@ func (c *Client) FetchInstance(ctx context.Context) (*FetchInstanceResponse, err
 }
 
 func (c *Client) ConnectInstance(ctx context.Context) (*ConnectInstanceResponse, error) {
+	return c.connectInstance(ctx, "")
+}
+
+// PairInstance connects the instance with a pairing code for number, to be
+// typed in WhatsApp instead of scanning the QR code.
+func (c *Client) PairInstance(ctx context.Context, number string) (*ConnectInstanceResponse, error) {
+	if number == "" {
+		return nil, fmt.Errorf("number is required")
+	}
+	return c.connectInstance(ctx, number)
+}
+
+func (c *Client) connectInstance(ctx context.Context, number string) (*ConnectInstanceResponse, error) {
 	if c.instance == "" {
 		return nil, fmt.Errorf("instance is required")
 	}
@@ -90,6 +106,11 @@ func (c *Client) ConnectInstance(ctx context.Context) (*ConnectInstanceResponse,
 	if err != nil {
 		return nil, err
 	}
+	if number != "" {
+		q := req.URL.Query()
+		q.Set("number", number)
+		req.URL.RawQuery = q.Encode()
+	}
 	jr, _, err := c.do(req)
 	if err != nil {
 		return nil, err
@@ -117,6 +138,24 @@ func (c *Client) LogoutInstance(ctx context.Context) (*json.RawMessage, error) {
 	return &jr, nil
 }
 
+// RestartInstance reopens the WhatsApp connection of the instance, keeping
+// its login and settings.
+func (c *Client) RestartInstance(ctx context.Context) (*json.RawMessage, error) {
+	if c.instance == "" {
+		return nil, fmt.Errorf("instance is required")
+	}
+	p := "/instance/restart/" + url.PathEscape(c.instance)
+	req, err := c.newRequest(ctx, http.MethodPut, p, nil)
+	if err != nil {
+		return nil, err
+	}
+	jr, _, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return &jr, nil
+}
+
 func (c *Client) DeleteInstance(ctx context.Context) (*json.RawMessage, error) {
 	if c.instance == "" {
 		return nil, fmt.Errorf("instance is required")
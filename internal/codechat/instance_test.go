@@ -0,0 +1,56 @@
+package codechat
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPairInstance_SendsNumber(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/instance/connect/inst" {
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+		if got := r.URL.Query().Get("number"); got != "5511912345678" {
+			t.Fatalf("number = %q, want 5511912345678", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"pairingCode":"WZYEH1YY","code":"2@abc","count":1}`))
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, "tok", WithInstanceToken("itok", "inst"))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	resp, err := c.PairInstance(context.Background(), "5511912345678")
+	if err != nil {
+		t.Fatalf("PairInstance() error: %v", err)
+	}
+	if resp.PairingCode != "WZYEH1YY" {
+		t.Fatalf("PairingCode = %q, want WZYEH1YY", resp.PairingCode)
+	}
+}
+
+func TestRestartInstance(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/instance/restart/inst" {
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer itok" {
+			t.Fatalf("Authorization = %q, want Bearer itok", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"error":false,"message":"Instance restarted"}`))
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, "tok", WithInstanceToken("itok", "inst"))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	if _, err := c.RestartInstance(context.Background()); err != nil {
+		t.Fatalf("RestartInstance() error: %v", err)
+	}
+}
@@ -47,7 +47,17 @@ type SendMediaParams struct {
 	MediaMessage CCMediaMessage    `json:"mediaMessage"`
 }
 
-func (c *Client) messageRequest(ctx context.Context, path string, payload any) (json.RawMessage, error) {
+// SentMessage is the message Codechat returns once it's sent. Its key ID is
+// the one of the fromMe upsert Codechat posts for it afterwards.
+type SentMessage struct {
+	Key struct {
+		RemoteJid string `json:"remoteJid"`
+		FromMe    bool   `json:"fromMe"`
+		ID        string `json:"id"`
+	} `json:"key"`
+}
+
+func (c *Client) messageRequest(ctx context.Context, path string, payload any) (*SentMessage, error) {
 	if c.instance == "" {
 		return nil, fmt.Errorf("instanceName is required")
 	}
@@ -57,17 +67,28 @@ func (c *Client) messageRequest(ctx context.Context, path string, payload any) (
 		return nil, err
 	}
 	jr, _, err := c.do(req)
-	return jr, err
+	if err != nil {
+		return nil, err
+	}
+	var sent SentMessage
+	if len(jr) == 0 {
+		// Sent all the same, only the key is unknown
+		return &sent, nil
+	}
+	if err := json.Unmarshal(jr, &sent); err != nil {
+		return nil, fmt.Errorf("decode sent message: %w", err)
+	}
+	return &sent, nil
 }
 
-func (c *Client) SendText(ctx context.Context, payload SendTextParams) (json.RawMessage, error) {
+func (c *Client) SendText(ctx context.Context, payload SendTextParams) (*SentMessage, error) {
 	return c.messageRequest(ctx, "sendText", payload)
 }
 
-func (c *Client) SendWhatsappAudio(ctx context.Context, payload SendWhatsappAudioParams) (json.RawMessage, error) {
+func (c *Client) SendWhatsappAudio(ctx context.Context, payload SendWhatsappAudioParams) (*SentMessage, error) {
 	return c.messageRequest(ctx, "sendWhatsappAudio", payload)
 }
 
-func (c *Client) SendMedia(ctx context.Context, payload SendMediaParams) (json.RawMessage, error) {
+func (c *Client) SendMedia(ctx context.Context, payload SendMediaParams) (*SentMessage, error) {
 	return c.messageRequest(ctx, "sendMedia", payload)
 }
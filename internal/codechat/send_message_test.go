@@ -0,0 +1,43 @@
+package codechat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendText(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/message/sendText/inst" {
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		var body SendTextParams
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+		if body.Number != "5511988776655" || body.TextMessage.Text != "hello" {
+			t.Fatalf("body = %+v", body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"key":{"remoteJid":"5511988776655@s.whatsapp.net","fromMe":true,"id":"BAE5F2D1C0A9"},"messageTimestamp":"1718000000","status":"PENDING"}`))
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, "tok", WithInstanceToken("itok", "inst"))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	sent, err := c.SendText(context.Background(), SendTextParams{
+		Number:      "5511988776655",
+		TextMessage: CCTextMessage{Text: "hello"},
+	})
+	if err != nil {
+		t.Fatalf("SendText() error: %v", err)
+	}
+	if sent.Key.ID != "BAE5F2D1C0A9" || !sent.Key.FromMe {
+		t.Fatalf("key = %+v", sent.Key)
+	}
+}
@@ -45,6 +45,8 @@ func NewSetWebhookParams(url string) *SetWebhookParams {
 		URL:     url,
 	}
 	p.Events.MessagesUpsert = true
+	p.Events.QrcodeUpdated = true
+	p.Events.ConnectionUpdated = true
 	return p
 }
 
@@ -1,16 +1,20 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/sdrvirtual/codewoot/internal/secrets"
 )
 
 type Config struct {
 	Server struct {
-		Port string
-		Host string
-		URL  string
+		Port            string
+		Host            string
+		URL             string
+		ShutdownTimeout time.Duration
 	}
 
 	Chatwoot struct {
@@ -29,6 +33,25 @@ type Config struct {
 	Authorization struct {
 		Key string
 	}
+
+	Sessions struct {
+		CacheTTL time.Duration
+		// How often the Codechat webhooks are checked, 0 disables it
+		WebhookReconcileInterval time.Duration
+		// How often contact names and avatars are refreshed from WhatsApp,
+		// 0 disables it
+		ContactSyncInterval time.Duration
+	}
+
+	Relay struct {
+		Timeout time.Duration
+		// How long outbound messages wait for a disconnected instance
+		QueueTTL time.Duration
+	}
+
+	Encryption struct {
+		Keys *secrets.Keyring
+	}
 }
 
 func Load() (*Config, error) {
@@ -38,6 +61,7 @@ func Load() (*Config, error) {
 	cfg.Server.Port = getEnv("PORT", "8080")
 	cfg.Server.Host = getEnv("HOST", "0.0.0.0")
 	cfg.Server.URL = getEnv("API_URL", "http://localhost:8080")
+	cfg.Server.ShutdownTimeout = getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
 
 	cfg.Chatwoot.URL = os.Getenv("CHATWOOT_URL")
 
@@ -47,6 +71,18 @@ func Load() (*Config, error) {
 	cfg.Database.URL = os.Getenv("DB_URL")
 	cfg.Authorization.Key = os.Getenv("API_KEY")
 
+	cfg.Sessions.CacheTTL = getEnvDuration("SESSION_CACHE_TTL", 5*time.Minute)
+	cfg.Sessions.WebhookReconcileInterval = getEnvDuration("WEBHOOK_RECONCILE_INTERVAL", 15*time.Minute)
+	cfg.Sessions.ContactSyncInterval = getEnvDuration("CONTACT_SYNC_INTERVAL", 24*time.Hour)
+	cfg.Relay.Timeout = getEnvDuration("RELAY_TIMEOUT", time.Minute)
+	cfg.Relay.QueueTTL = getEnvDuration("OUTBOUND_QUEUE_TTL", time.Hour)
+
+	keys, err := secrets.NewKeyring(os.Getenv("ENCRYPTION_KEYS"))
+	if err != nil {
+		return nil, fmt.Errorf("ENCRYPTION_KEYS: %w", err)
+	}
+	cfg.Encryption.Keys = keys
+
 	return cfg, nil
 }
 
@@ -56,3 +92,12 @@ func getEnv(key, def string) string {
 	}
 	return def
 }
+
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return def
+}
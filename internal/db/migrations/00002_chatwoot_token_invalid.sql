@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE codechat_session
+      ADD COLUMN chatwoot_token_invalid BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE codechat_session
+      DROP COLUMN chatwoot_token_invalid;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE codechat_session
+      ADD COLUMN webhook_secret VARCHAR(255) NOT NULL DEFAULT '',
+      ADD COLUMN chatwoot_hmac_secret VARCHAR(1024);
+-- +goose StatementEnd
+
+-- Existing sessions get a secret too. The webhook reconciler registers
+-- their Codechat and Chatwoot inbox webhooks again with it on startup.
+-- +goose StatementBegin
+UPDATE codechat_session
+   SET webhook_secret = replace(gen_random_uuid()::text || gen_random_uuid()::text, '-', '')
+ WHERE webhook_secret = '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE codechat_session
+      DROP COLUMN webhook_secret,
+      DROP COLUMN chatwoot_hmac_secret;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE codechat_session
+      ALTER COLUMN codechat_instcance_token TYPE TEXT,
+      ALTER COLUMN chatwoot_token TYPE TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE codechat_session
+      ALTER COLUMN codechat_instcance_token TYPE VARCHAR(1024),
+      ALTER COLUMN chatwoot_token TYPE VARCHAR(1024);
+-- +goose StatementEnd
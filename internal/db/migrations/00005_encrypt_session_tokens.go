@@ -0,0 +1,84 @@
+// Package migrations registers the Go migrations, the SQL ones are read
+// from this directory by goose.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"github.com/pressly/goose/v3"
+	"github.com/sdrvirtual/codewoot/internal/secrets"
+)
+
+func init() {
+	goose.AddMigrationContext(upEncryptSessionTokens, downEncryptSessionTokens)
+}
+
+// upEncryptSessionTokens encrypts the tokens stored in plaintext. The
+// keyring comes from the migration context, without one there is nothing
+// to do and rows stay in plaintext until codewoot-rekey is run.
+func upEncryptSessionTokens(ctx context.Context, tx *sql.Tx) error {
+	keys := secrets.FromContext(ctx)
+	if !keys.Enabled() {
+		log.Printf("ENCRYPTION_KEYS not set, session tokens are kept in plaintext")
+		return nil
+	}
+	return rewriteSessionTokens(ctx, tx, func(v string) (string, error) {
+		if secrets.IsEncrypted(v) {
+			return v, nil
+		}
+		return keys.Encrypt(v)
+	})
+}
+
+func downEncryptSessionTokens(ctx context.Context, tx *sql.Tx) error {
+	keys := secrets.FromContext(ctx)
+	return rewriteSessionTokens(ctx, tx, keys.Decrypt)
+}
+
+func rewriteSessionTokens(ctx context.Context, tx *sql.Tx, rewrite func(string) (string, error)) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, codechat_instcance_token, chatwoot_token FROM codechat_session`)
+	if err != nil {
+		return err
+	}
+	type session struct {
+		id            int32
+		codechatToken string
+		chatwootToken string
+	}
+	var sessions []session
+	for rows.Next() {
+		var s session
+		if err := rows.Scan(&s.id, &s.codechatToken, &s.chatwootToken); err != nil {
+			rows.Close()
+			return err
+		}
+		sessions = append(sessions, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range sessions {
+		codechatToken, err := rewrite(s.codechatToken)
+		if err != nil {
+			return err
+		}
+		chatwootToken, err := rewrite(s.chatwootToken)
+		if err != nil {
+			return err
+		}
+		if codechatToken == s.codechatToken && chatwootToken == s.chatwootToken {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE codechat_session SET codechat_instcance_token = $2, chatwoot_token = $3 WHERE id = $1`,
+			s.id, codechatToken, chatwootToken,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
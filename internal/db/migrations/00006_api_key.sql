@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE api_key (
+       id SERIAL PRIMARY KEY,
+       name VARCHAR(255) NOT NULL,
+       prefix VARCHAR(32) NOT NULL UNIQUE,
+       key_hash BYTEA NOT NULL,
+       permissions TEXT[] NOT NULL DEFAULT '{}',
+       session_ids UUID[] NOT NULL DEFAULT '{}',
+       chatwoot_account_ids INT[] NOT NULL DEFAULT '{}',
+       revoked_at TIMESTAMPTZ,
+       created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+       updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER update_api_key_updated_at
+BEFORE UPDATE ON api_key
+FOR EACH ROW
+EXECUTE FUNCTION update_modified_column();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE api_key;
+-- +goose StatementEnd
@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE codechat_session
+      ADD COLUMN connection_state VARCHAR(32) NOT NULL DEFAULT '',
+      ADD COLUMN connection_state_at TIMESTAMPTZ;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE connection_event (
+       id SERIAL PRIMARY KEY,
+       session_id UUID NOT NULL REFERENCES codechat_session (session_id) ON DELETE CASCADE,
+       state VARCHAR(32) NOT NULL,
+       status_reason int NOT NULL DEFAULT 0,
+       created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX connection_event_session_id_idx ON connection_event (session_id, created_at DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE connection_event;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE codechat_session
+      DROP COLUMN connection_state,
+      DROP COLUMN connection_state_at;
+-- +goose StatementEnd
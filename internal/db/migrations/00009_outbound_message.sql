@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE outbound_message (
+       id SERIAL PRIMARY KEY,
+       session_id UUID NOT NULL REFERENCES codechat_session (session_id) ON DELETE CASCADE,
+       conversation_id int NOT NULL,
+       payload JSONB NOT NULL,
+       created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+       expires_at TIMESTAMPTZ NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX outbound_message_session_id_idx ON outbound_message (session_id, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE outbound_message;
+-- +goose StatementEnd
@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE codechat_session ADD COLUMN settings JSONB NOT NULL DEFAULT '{}'::jsonb;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE codechat_session DROP COLUMN settings;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- Two sessions bridging one instance would both get its messages. Those
+-- have to be sorted out by hand, the migration stops until they are.
+-- +goose StatementBegin
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    SELECT string_agg(codechat_instance, ', ') INTO duplicates FROM (
+        SELECT codechat_instance FROM codechat_session
+        GROUP BY codechat_instance
+        HAVING count(*) > 1
+    ) d;
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'codechat instances bridged by more than one session: %, delete the extra sessions and migrate again', duplicates;
+    END IF;
+END
+$$;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE UNIQUE INDEX codechat_session_codechat_instance_key ON codechat_session (codechat_instance);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX codechat_session_codechat_instance_key;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE codechat_session
+      ADD COLUMN webhook_error TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE codechat_session
+      DROP COLUMN webhook_error;
+-- +goose StatementEnd
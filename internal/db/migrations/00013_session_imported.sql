@@ -0,0 +1,13 @@
+-- +goose Up
+-- Imported sessions bridge an instance created outside the service, it's
+-- left in place when the session is deleted.
+-- +goose StatementBegin
+ALTER TABLE codechat_session
+      ADD COLUMN imported BOOLEAN NOT NULL DEFAULT false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE codechat_session
+      DROP COLUMN imported;
+-- +goose StatementEnd
@@ -6,8 +6,22 @@ package db
 
 import (
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sdrvirtual/codewoot/internal/domain"
 )
 
+type ApiKey struct {
+	ID                 int32
+	Name               string
+	Prefix             string
+	KeyHash            []byte
+	Permissions        []string
+	SessionIds         []pgtype.UUID
+	ChatwootAccountIds []int32
+	RevokedAt          pgtype.Timestamptz
+	CreatedAt          pgtype.Timestamptz
+	UpdatedAt          pgtype.Timestamptz
+}
+
 type CodechatSession struct {
 	ID                     int32
 	SessionID              pgtype.UUID
@@ -18,4 +32,30 @@ type CodechatSession struct {
 	ChatwootInboxID        int32
 	CreatedAt              pgtype.Timestamptz
 	UpdatedAt              pgtype.Timestamptz
+	ChatwootTokenInvalid   bool
+	WebhookSecret          string
+	ChatwootHmacSecret     pgtype.Text
+	Description            string
+	ConnectionState        string
+	ConnectionStateAt      pgtype.Timestamptz
+	Settings               domain.SessionSettings
+	WebhookError           string
+	Imported               bool
+}
+
+type ConnectionEvent struct {
+	ID           int32
+	SessionID    pgtype.UUID
+	State        string
+	StatusReason int32
+	CreatedAt    pgtype.Timestamptz
+}
+
+type OutboundMessage struct {
+	ID             int32
+	SessionID      pgtype.UUID
+	ConversationID int32
+	Payload        []byte
+	CreatedAt      pgtype.Timestamptz
+	ExpiresAt      pgtype.Timestamptz
 }
@@ -0,0 +1,25 @@
+-- name: CreateApiKey :one
+INSERT INTO api_key (
+    name,
+    prefix,
+    key_hash,
+    permissions,
+    session_ids,
+    chatwoot_account_ids
+) VALUES (
+  $1, $2, $3, $4, $5, $6
+)
+RETURNING *;
+
+-- name: GetApiKeyByPrefix :one
+SELECT * FROM api_key
+WHERE prefix = $1 LIMIT 1;
+
+-- name: ListApiKeys :many
+SELECT * FROM api_key
+ORDER BY id;
+
+-- name: RevokeApiKey :execrows
+UPDATE api_key
+  set revoked_at = NOW()
+WHERE id = $1 AND revoked_at IS NULL;
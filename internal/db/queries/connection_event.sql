@@ -0,0 +1,14 @@
+-- name: CreateConnectionEvent :exec
+INSERT INTO connection_event (
+    session_id,
+    state,
+    status_reason
+) VALUES (
+  $1, $2, $3
+);
+
+-- name: ListConnectionEvents :many
+SELECT * FROM connection_event
+WHERE session_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
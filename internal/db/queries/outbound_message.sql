@@ -0,0 +1,35 @@
+-- name: EnqueueOutboundMessage :exec
+INSERT INTO outbound_message (
+    session_id,
+    conversation_id,
+    payload,
+    expires_at
+) VALUES (
+  $1, $2, $3, $4
+);
+
+-- name: NextOutboundMessage :one
+SELECT * FROM outbound_message
+WHERE session_id = $1
+ORDER BY id
+LIMIT 1;
+
+-- name: DeleteOutboundMessage :exec
+DELETE FROM outbound_message
+WHERE id = $1;
+
+-- name: DeleteExpiredOutboundMessages :many
+DELETE FROM outbound_message
+WHERE expires_at <= NOW()
+RETURNING *;
+
+-- name: ShouldQueueOutbound :one
+SELECT (s.connection_state = 'close'
+        OR EXISTS (SELECT 1 FROM outbound_message o WHERE o.session_id = s.session_id))::bool AS should_queue
+FROM codechat_session s
+WHERE s.session_id = $1;
+
+-- name: ListFlushableOutboundSessions :many
+SELECT DISTINCT o.session_id FROM outbound_message o
+JOIN codechat_session s ON s.session_id = o.session_id
+WHERE s.connection_state = 'open';
@@ -6,6 +6,16 @@ WHERE id = $1 LIMIT 1;
 -- name: ListSessions :many
 SELECT * FROM codechat_session;
 
+-- name: ListSessionsFiltered :many
+SELECT * FROM codechat_session
+WHERE (sqlc.narg('chatwoot_account_id')::int IS NULL OR chatwoot_account_id = sqlc.narg('chatwoot_account_id'))
+  AND (sqlc.narg('chatwoot_inbox_id')::int IS NULL OR chatwoot_inbox_id = sqlc.narg('chatwoot_inbox_id'))
+  AND (NOT sqlc.arg('scoped')::bool
+       OR session_id = ANY(sqlc.arg('scope_session_ids')::uuid[])
+       OR chatwoot_account_id = ANY(sqlc.arg('scope_account_ids')::int[]))
+ORDER BY id
+LIMIT sqlc.narg('limit') OFFSET sqlc.arg('offset');
+
 -- name: GetSessionBySessionId :one
 SELECT * FROM codechat_session
 WHERE session_id = $1 LIMIT 1;
@@ -17,9 +27,14 @@ INSERT INTO codechat_session (
     codechat_instcance_token,
     chatwoot_token,
     chatwoot_account_id,
-    chatwoot_inbox_id
+    chatwoot_inbox_id,
+    webhook_secret,
+    chatwoot_hmac_secret,
+    description,
+    settings,
+    imported
 ) VALUES (
-  $1, $2, $3, $4, $5, $6
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
 )
 RETURNING *;
 
@@ -38,5 +53,36 @@ UPDATE codechat_session
   codechat_instcance_token = $4,
   chatwoot_token = $5,
   chatwoot_account_id = $6,
-  chatwoot_inbox_id = $7
+  chatwoot_inbox_id = $7,
+  description = $8,
+  settings = $9
 WHERE id =  $1;
+
+-- name: SetChatwootTokenInvalid :exec
+UPDATE codechat_session
+  set chatwoot_token_invalid = $2
+WHERE session_id = $1;
+
+-- name: SetWebhookError :exec
+UPDATE codechat_session
+  set webhook_error = $2
+WHERE session_id = $1;
+
+-- name: UpdateSessionTokens :exec
+UPDATE codechat_session
+  set codechat_instcance_token = $2,
+  chatwoot_token = $3
+WHERE id = $1;
+
+-- name: UpdateConnectionState :one
+UPDATE codechat_session s
+  set connection_state = $2,
+  connection_state_at = NOW()
+FROM codechat_session old
+WHERE s.id = old.id AND s.session_id = $1
+RETURNING old.connection_state;
+
+-- name: InstanceHasSession :one
+SELECT EXISTS (
+    SELECT 1 FROM codechat_session WHERE codechat_instance = $1
+)::bool AS has_session;
@@ -9,6 +9,7 @@ import (
 	"context"
 
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sdrvirtual/codewoot/internal/domain"
 )
 
 const createSession = `-- name: CreateSession :one
@@ -18,11 +19,16 @@ INSERT INTO codechat_session (
     codechat_instcance_token,
     chatwoot_token,
     chatwoot_account_id,
-    chatwoot_inbox_id
+    chatwoot_inbox_id,
+    webhook_secret,
+    chatwoot_hmac_secret,
+    description,
+    settings,
+    imported
 ) VALUES (
-  $1, $2, $3, $4, $5, $6
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
 )
-RETURNING id, session_id, codechat_instance, codechat_instcance_token, chatwoot_token, chatwoot_account_id, chatwoot_inbox_id, created_at, updated_at
+RETURNING id, session_id, codechat_instance, codechat_instcance_token, chatwoot_token, chatwoot_account_id, chatwoot_inbox_id, created_at, updated_at, chatwoot_token_invalid, webhook_secret, chatwoot_hmac_secret, description, connection_state, connection_state_at, settings, webhook_error, imported
 `
 
 type CreateSessionParams struct {
@@ -32,6 +38,11 @@ type CreateSessionParams struct {
 	ChatwootToken          string
 	ChatwootAccountID      int32
 	ChatwootInboxID        int32
+	WebhookSecret          string
+	ChatwootHmacSecret     pgtype.Text
+	Description            string
+	Settings               domain.SessionSettings
+	Imported               bool
 }
 
 func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (CodechatSession, error) {
@@ -42,6 +53,11 @@ func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (C
 		arg.ChatwootToken,
 		arg.ChatwootAccountID,
 		arg.ChatwootInboxID,
+		arg.WebhookSecret,
+		arg.ChatwootHmacSecret,
+		arg.Description,
+		arg.Settings,
+		arg.Imported,
 	)
 	var i CodechatSession
 	err := row.Scan(
@@ -54,6 +70,15 @@ func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (C
 		&i.ChatwootInboxID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.ChatwootTokenInvalid,
+		&i.WebhookSecret,
+		&i.ChatwootHmacSecret,
+		&i.Description,
+		&i.ConnectionState,
+		&i.ConnectionStateAt,
+		&i.Settings,
+		&i.WebhookError,
+		&i.Imported,
 	)
 	return i, err
 }
@@ -79,7 +104,7 @@ func (q *Queries) DeleteSessionBySessionId(ctx context.Context, sessionID pgtype
 }
 
 const getSession = `-- name: GetSession :one
-SELECT id, session_id, codechat_instance, codechat_instcance_token, chatwoot_token, chatwoot_account_id, chatwoot_inbox_id, created_at, updated_at FROM codechat_session
+SELECT id, session_id, codechat_instance, codechat_instcance_token, chatwoot_token, chatwoot_account_id, chatwoot_inbox_id, created_at, updated_at, chatwoot_token_invalid, webhook_secret, chatwoot_hmac_secret, description, connection_state, connection_state_at, settings, webhook_error, imported FROM codechat_session
 WHERE id = $1 LIMIT 1
 `
 
@@ -96,12 +121,21 @@ func (q *Queries) GetSession(ctx context.Context, id int32) (CodechatSession, er
 		&i.ChatwootInboxID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.ChatwootTokenInvalid,
+		&i.WebhookSecret,
+		&i.ChatwootHmacSecret,
+		&i.Description,
+		&i.ConnectionState,
+		&i.ConnectionStateAt,
+		&i.Settings,
+		&i.WebhookError,
+		&i.Imported,
 	)
 	return i, err
 }
 
 const getSessionBySessionId = `-- name: GetSessionBySessionId :one
-SELECT id, session_id, codechat_instance, codechat_instcance_token, chatwoot_token, chatwoot_account_id, chatwoot_inbox_id, created_at, updated_at FROM codechat_session
+SELECT id, session_id, codechat_instance, codechat_instcance_token, chatwoot_token, chatwoot_account_id, chatwoot_inbox_id, created_at, updated_at, chatwoot_token_invalid, webhook_secret, chatwoot_hmac_secret, description, connection_state, connection_state_at, settings, webhook_error, imported FROM codechat_session
 WHERE session_id = $1 LIMIT 1
 `
 
@@ -118,12 +152,34 @@ func (q *Queries) GetSessionBySessionId(ctx context.Context, sessionID pgtype.UU
 		&i.ChatwootInboxID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.ChatwootTokenInvalid,
+		&i.WebhookSecret,
+		&i.ChatwootHmacSecret,
+		&i.Description,
+		&i.ConnectionState,
+		&i.ConnectionStateAt,
+		&i.Settings,
+		&i.WebhookError,
+		&i.Imported,
 	)
 	return i, err
 }
 
+const instanceHasSession = `-- name: InstanceHasSession :one
+SELECT EXISTS (
+    SELECT 1 FROM codechat_session WHERE codechat_instance = $1
+)::bool AS has_session
+`
+
+func (q *Queries) InstanceHasSession(ctx context.Context, codechatInstance string) (bool, error) {
+	row := q.db.QueryRow(ctx, instanceHasSession, codechatInstance)
+	var has_session bool
+	err := row.Scan(&has_session)
+	return has_session, err
+}
+
 const listSessions = `-- name: ListSessions :many
-SELECT id, session_id, codechat_instance, codechat_instcance_token, chatwoot_token, chatwoot_account_id, chatwoot_inbox_id, created_at, updated_at FROM codechat_session
+SELECT id, session_id, codechat_instance, codechat_instcance_token, chatwoot_token, chatwoot_account_id, chatwoot_inbox_id, created_at, updated_at, chatwoot_token_invalid, webhook_secret, chatwoot_hmac_secret, description, connection_state, connection_state_at, settings, webhook_error, imported FROM codechat_session
 `
 
 func (q *Queries) ListSessions(ctx context.Context) ([]CodechatSession, error) {
@@ -145,6 +201,15 @@ func (q *Queries) ListSessions(ctx context.Context) ([]CodechatSession, error) {
 			&i.ChatwootInboxID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.ChatwootTokenInvalid,
+			&i.WebhookSecret,
+			&i.ChatwootHmacSecret,
+			&i.Description,
+			&i.ConnectionState,
+			&i.ConnectionStateAt,
+			&i.Settings,
+			&i.WebhookError,
+			&i.Imported,
 		); err != nil {
 			return nil, err
 		}
@@ -156,6 +221,127 @@ func (q *Queries) ListSessions(ctx context.Context) ([]CodechatSession, error) {
 	return items, nil
 }
 
+const listSessionsFiltered = `-- name: ListSessionsFiltered :many
+SELECT id, session_id, codechat_instance, codechat_instcance_token, chatwoot_token, chatwoot_account_id, chatwoot_inbox_id, created_at, updated_at, chatwoot_token_invalid, webhook_secret, chatwoot_hmac_secret, description, connection_state, connection_state_at, settings, webhook_error, imported FROM codechat_session
+WHERE ($1::int IS NULL OR chatwoot_account_id = $1)
+  AND ($2::int IS NULL OR chatwoot_inbox_id = $2)
+  AND (NOT $3::bool
+       OR session_id = ANY($4::uuid[])
+       OR chatwoot_account_id = ANY($5::int[]))
+ORDER BY id
+LIMIT $6 OFFSET $7
+`
+
+type ListSessionsFilteredParams struct {
+	ChatwootAccountID pgtype.Int4
+	ChatwootInboxID   pgtype.Int4
+	Scoped            bool
+	ScopeSessionIds   []pgtype.UUID
+	ScopeAccountIds   []int32
+	Limit             pgtype.Int4
+	Offset            int32
+}
+
+func (q *Queries) ListSessionsFiltered(ctx context.Context, arg ListSessionsFilteredParams) ([]CodechatSession, error) {
+	rows, err := q.db.Query(ctx, listSessionsFiltered,
+		arg.ChatwootAccountID,
+		arg.ChatwootInboxID,
+		arg.Scoped,
+		arg.ScopeSessionIds,
+		arg.ScopeAccountIds,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CodechatSession
+	for rows.Next() {
+		var i CodechatSession
+		if err := rows.Scan(
+			&i.ID,
+			&i.SessionID,
+			&i.CodechatInstance,
+			&i.CodechatInstcanceToken,
+			&i.ChatwootToken,
+			&i.ChatwootAccountID,
+			&i.ChatwootInboxID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.ChatwootTokenInvalid,
+			&i.WebhookSecret,
+			&i.ChatwootHmacSecret,
+			&i.Description,
+			&i.ConnectionState,
+			&i.ConnectionStateAt,
+			&i.Settings,
+			&i.WebhookError,
+			&i.Imported,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setChatwootTokenInvalid = `-- name: SetChatwootTokenInvalid :exec
+UPDATE codechat_session
+  set chatwoot_token_invalid = $2
+WHERE session_id = $1
+`
+
+type SetChatwootTokenInvalidParams struct {
+	SessionID            pgtype.UUID
+	ChatwootTokenInvalid bool
+}
+
+func (q *Queries) SetChatwootTokenInvalid(ctx context.Context, arg SetChatwootTokenInvalidParams) error {
+	_, err := q.db.Exec(ctx, setChatwootTokenInvalid, arg.SessionID, arg.ChatwootTokenInvalid)
+	return err
+}
+
+const setWebhookError = `-- name: SetWebhookError :exec
+UPDATE codechat_session
+  set webhook_error = $2
+WHERE session_id = $1
+`
+
+type SetWebhookErrorParams struct {
+	SessionID    pgtype.UUID
+	WebhookError string
+}
+
+func (q *Queries) SetWebhookError(ctx context.Context, arg SetWebhookErrorParams) error {
+	_, err := q.db.Exec(ctx, setWebhookError, arg.SessionID, arg.WebhookError)
+	return err
+}
+
+const updateConnectionState = `-- name: UpdateConnectionState :one
+UPDATE codechat_session s
+  set connection_state = $2,
+  connection_state_at = NOW()
+FROM codechat_session old
+WHERE s.id = old.id AND s.session_id = $1
+RETURNING old.connection_state
+`
+
+type UpdateConnectionStateParams struct {
+	SessionID       pgtype.UUID
+	ConnectionState string
+}
+
+func (q *Queries) UpdateConnectionState(ctx context.Context, arg UpdateConnectionStateParams) (string, error) {
+	row := q.db.QueryRow(ctx, updateConnectionState, arg.SessionID, arg.ConnectionState)
+	var connection_state string
+	err := row.Scan(&connection_state)
+	return connection_state, err
+}
+
 const updateSession = `-- name: UpdateSession :exec
 UPDATE codechat_session
   set session_id = $2,
@@ -163,7 +349,9 @@ UPDATE codechat_session
   codechat_instcance_token = $4,
   chatwoot_token = $5,
   chatwoot_account_id = $6,
-  chatwoot_inbox_id = $7
+  chatwoot_inbox_id = $7,
+  description = $8,
+  settings = $9
 WHERE id =  $1
 `
 
@@ -175,6 +363,8 @@ type UpdateSessionParams struct {
 	ChatwootToken          string
 	ChatwootAccountID      int32
 	ChatwootInboxID        int32
+	Description            string
+	Settings               domain.SessionSettings
 }
 
 func (q *Queries) UpdateSession(ctx context.Context, arg UpdateSessionParams) error {
@@ -186,6 +376,26 @@ func (q *Queries) UpdateSession(ctx context.Context, arg UpdateSessionParams) er
 		arg.ChatwootToken,
 		arg.ChatwootAccountID,
 		arg.ChatwootInboxID,
+		arg.Description,
+		arg.Settings,
 	)
 	return err
 }
+
+const updateSessionTokens = `-- name: UpdateSessionTokens :exec
+UPDATE codechat_session
+  set codechat_instcance_token = $2,
+  chatwoot_token = $3
+WHERE id = $1
+`
+
+type UpdateSessionTokensParams struct {
+	ID                     int32
+	CodechatInstcanceToken string
+	ChatwootToken          string
+}
+
+func (q *Queries) UpdateSessionTokens(ctx context.Context, arg UpdateSessionTokensParams) error {
+	_, err := q.db.Exec(ctx, updateSessionTokens, arg.ID, arg.CodechatInstcanceToken, arg.ChatwootToken)
+	return err
+}
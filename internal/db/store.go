@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sdrvirtual/codewoot/internal/secrets"
+)
+
+// Store wraps the generated queries, encrypting the Codechat and Chatwoot
+// tokens on write and decrypting them on read. Callers always see plaintext.
+type Store struct {
+	*Queries
+	keys *secrets.Keyring
+}
+
+func NewStore(db DBTX, keys *secrets.Keyring) *Store {
+	return &Store{Queries: New(db), keys: keys}
+}
+
+func (s *Store) GetSession(ctx context.Context, id int32) (CodechatSession, error) {
+	session, err := s.Queries.GetSession(ctx, id)
+	if err != nil {
+		return session, err
+	}
+	return session, s.decrypt(&session)
+}
+
+func (s *Store) GetSessionBySessionId(ctx context.Context, sessionID pgtype.UUID) (CodechatSession, error) {
+	session, err := s.Queries.GetSessionBySessionId(ctx, sessionID)
+	if err != nil {
+		return session, err
+	}
+	return session, s.decrypt(&session)
+}
+
+// ListSessions returns every session. Sessions whose tokens can't be
+// decrypted are returned without them, their error is in broken, keyed by
+// session ID.
+func (s *Store) ListSessions(ctx context.Context) (sessions []CodechatSession, broken map[string]error, err error) {
+	sessions, err = s.Queries.ListSessions(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sessions, s.decryptAll(sessions), nil
+}
+
+// ListSessionsFiltered is ListSessions for a filtered page.
+func (s *Store) ListSessionsFiltered(ctx context.Context, arg ListSessionsFilteredParams) (sessions []CodechatSession, broken map[string]error, err error) {
+	sessions, err = s.Queries.ListSessionsFiltered(ctx, arg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sessions, s.decryptAll(sessions), nil
+}
+
+func (s *Store) CreateSession(ctx context.Context, arg CreateSessionParams) (CodechatSession, error) {
+	var err error
+	if arg.CodechatInstcanceToken, err = s.keys.Encrypt(arg.CodechatInstcanceToken); err != nil {
+		return CodechatSession{}, err
+	}
+	if arg.ChatwootToken, err = s.keys.Encrypt(arg.ChatwootToken); err != nil {
+		return CodechatSession{}, err
+	}
+	session, err := s.Queries.CreateSession(ctx, arg)
+	if err != nil {
+		return session, err
+	}
+	return session, s.decrypt(&session)
+}
+
+func (s *Store) UpdateSession(ctx context.Context, arg UpdateSessionParams) error {
+	var err error
+	if arg.CodechatInstcanceToken, err = s.keys.Encrypt(arg.CodechatInstcanceToken); err != nil {
+		return err
+	}
+	if arg.ChatwootToken, err = s.keys.Encrypt(arg.ChatwootToken); err != nil {
+		return err
+	}
+	return s.Queries.UpdateSession(ctx, arg)
+}
+
+func (s *Store) UpdateSessionTokens(ctx context.Context, arg UpdateSessionTokensParams) error {
+	var err error
+	if arg.CodechatInstcanceToken, err = s.keys.Encrypt(arg.CodechatInstcanceToken); err != nil {
+		return err
+	}
+	if arg.ChatwootToken, err = s.keys.Encrypt(arg.ChatwootToken); err != nil {
+		return err
+	}
+	return s.Queries.UpdateSessionTokens(ctx, arg)
+}
+
+// decryptAll decrypts the tokens of sessions in place. One session failing
+// doesn't stop the others, its tokens are cleared so the ciphertext is
+// never used as a token.
+func (s *Store) decryptAll(sessions []CodechatSession) map[string]error {
+	broken := make(map[string]error)
+	for i := range sessions {
+		if err := s.decrypt(&sessions[i]); err != nil {
+			sessions[i].CodechatInstcanceToken = ""
+			sessions[i].ChatwootToken = ""
+			broken[sessions[i].SessionID.String()] = err
+		}
+	}
+	return broken
+}
+
+func (s *Store) decrypt(session *CodechatSession) error {
+	var err error
+	if session.CodechatInstcanceToken, err = s.keys.Decrypt(session.CodechatInstcanceToken); err != nil {
+		return fmt.Errorf("session %s: codechat token: %w", session.SessionID.String(), err)
+	}
+	if session.ChatwootToken, err = s.keys.Decrypt(session.ChatwootToken); err != nil {
+		return fmt.Errorf("session %s: chatwoot token: %w", session.SessionID.String(), err)
+	}
+	return nil
+}
@@ -1,6 +1,62 @@
 package domain
 
+import "strings"
+
 type ContactInfo struct {
 	Name  string
 	Phone string
+	// WhatsApp JID, e.g. 5511988776655@s.whatsapp.net. Stored as the
+	// Chatwoot contact identifier when known.
+	JID string
+	// Only set on messages from WhatsApp
+	Metadata *WhatsAppMetadata
+}
+
+// Destination is where Codechat delivers messages for the contact, its JID
+// when known, the phone otherwise.
+func (c ContactInfo) Destination() string {
+	if c.JID != "" {
+		return c.JID
+	}
+	return c.Phone
+}
+
+// IsUserJID reports whether s is the JID of a WhatsApp user, by phone
+// number or LID.
+func IsUserJID(s string) bool {
+	user, server, ok := strings.Cut(s, "@")
+	return ok && user != "" && (server == "s.whatsapp.net" || server == "lid")
+}
+
+// WhatsAppMetadata is written to the Chatwoot contact and conversation
+// attributes, so automations and reports can filter by it.
+type WhatsAppMetadata struct {
+	JID string
+	// Set when WhatsApp hides the phone number behind a LID
+	LID string
+	// android, ios, web...
+	Device string
+	// Only known for verified business accounts
+	VerifiedBizName string
+	Instance        string
+}
+
+// Attributes returns the metadata keyed as in Chatwoot, leaving out what
+// isn't known.
+func (m WhatsAppMetadata) Attributes() map[string]any {
+	attrs := map[string]any{}
+	set := func(key, value string) {
+		if value != "" {
+			attrs[key] = value
+		}
+	}
+	set("whatsapp_jid", m.JID)
+	set("whatsapp_lid", m.LID)
+	set("whatsapp_device", m.Device)
+	set("whatsapp_instance", m.Instance)
+	if m.VerifiedBizName != "" {
+		attrs["whatsapp_business"] = true
+		attrs["whatsapp_verified_name"] = m.VerifiedBizName
+	}
+	return attrs
 }
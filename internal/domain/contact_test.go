@@ -0,0 +1,28 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWhatsAppMetadata_Attributes(t *testing.T) {
+	m := WhatsAppMetadata{
+		JID:      "5511988776655@s.whatsapp.net",
+		Device:   "android",
+		Instance: "support",
+	}
+	want := map[string]any{
+		"whatsapp_jid":      "5511988776655@s.whatsapp.net",
+		"whatsapp_device":   "android",
+		"whatsapp_instance": "support",
+	}
+	if got := m.Attributes(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Attributes() = %v, want %v", got, want)
+	}
+
+	m.VerifiedBizName = "ACME"
+	got := m.Attributes()
+	if got["whatsapp_business"] != true || got["whatsapp_verified_name"] != "ACME" {
+		t.Fatalf("Attributes() = %v, want the business flag", got)
+	}
+}
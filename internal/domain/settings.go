@@ -0,0 +1,121 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// Group messages are dropped
+	GroupsIgnore = "ignore"
+	// Group messages land in the conversation of the member who sent them
+	GroupsSender = "sender"
+
+	// A new message reopens the last resolved conversation of the contact
+	ReopenPolicyReopen = "reopen"
+	// A resolved conversation is left alone and a new one is started
+	ReopenPolicyNew = "new"
+	// The last resolved conversation is reopened when it was active in the
+	// last InactivityHours, otherwise a new one is started
+	ReopenPolicyInactivity = "inactivity"
+
+	// Placeholder of the agent name in Signature
+	SignatureAgent = "{agent}"
+
+	// Codechat events the relay handles, switched off in Events
+	EventMessagesUpsert   = "messages.upsert"
+	EventConnectionUpdate = "connection.update"
+	EventQRCodeUpdated    = "qrcode.updated"
+)
+
+// SessionSettings decide how the relay handles the messages of a session.
+// The zero value keeps the behavior sessions had before settings existed.
+type SessionSettings struct {
+	// GroupsIgnore (default) or GroupsSender
+	Groups string `json:"groups,omitempty"`
+	// Messages sent from the phone itself show up in Chatwoot as outgoing
+	MirrorFromMe bool `json:"mirror_from_me,omitempty"`
+	// Prefixed to agent replies, e.g. "*{agent}:*\n"
+	Signature string `json:"signature,omitempty"`
+	// Answered automatically when a customer message matches
+	AutoReplies []AutoReply `json:"auto_replies,omitempty"`
+	// ReopenPolicyReopen (default), ReopenPolicyNew or ReopenPolicyInactivity
+	ReopenPolicy    string        `json:"reopen_policy,omitempty"`
+	InactivityHours int           `json:"inactivity_hours,omitempty"`
+	Media           MediaSettings `json:"media"`
+	// Codechat events the relay handles, by name. Every event is handled
+	// unless it's set to false here.
+	Events map[string]bool `json:"events,omitempty"`
+}
+
+type AutoReply struct {
+	// Matches customer messages containing it, ignoring case
+	Keyword string `json:"keyword"`
+	Reply   string `json:"reply"`
+}
+
+type MediaSettings struct {
+	// Relay images from WhatsApp, they're rejected otherwise
+	Images bool `json:"images,omitempty"`
+	// Media from WhatsApp larger than this is rejected, 0 means no limit
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+}
+
+func (s SessionSettings) Validate() error {
+	switch s.Groups {
+	case "", GroupsIgnore, GroupsSender:
+	default:
+		return fmt.Errorf("groups must be %q or %q", GroupsIgnore, GroupsSender)
+	}
+	switch s.ReopenPolicy {
+	case "", ReopenPolicyReopen, ReopenPolicyNew:
+	case ReopenPolicyInactivity:
+		if s.InactivityHours <= 0 {
+			return fmt.Errorf("inactivity_hours must be positive with the %q reopen_policy", ReopenPolicyInactivity)
+		}
+	default:
+		return fmt.Errorf("reopen_policy must be %q, %q or %q", ReopenPolicyReopen, ReopenPolicyNew, ReopenPolicyInactivity)
+	}
+	for i, r := range s.AutoReplies {
+		if strings.TrimSpace(r.Keyword) == "" || strings.TrimSpace(r.Reply) == "" {
+			return fmt.Errorf("auto_replies[%d] needs a keyword and a reply", i)
+		}
+	}
+	if s.Media.MaxBytes < 0 {
+		return fmt.Errorf("media.max_bytes can't be negative")
+	}
+	for event := range s.Events {
+		switch event {
+		case EventMessagesUpsert, EventConnectionUpdate, EventQRCodeUpdated:
+		default:
+			return fmt.Errorf("events can only switch %q, %q or %q", EventMessagesUpsert, EventConnectionUpdate, EventQRCodeUpdated)
+		}
+	}
+	return nil
+}
+
+// HandlesEvent reports whether the relay acts on the Codechat event.
+func (s SessionSettings) HandlesEvent(event string) bool {
+	on, ok := s.Events[event]
+	return !ok || on
+}
+
+// Sign prefixes text with the signature for the agent, if there's one.
+func (s SessionSettings) Sign(agent, text string) string {
+	if s.Signature == "" || agent == "" {
+		return text
+	}
+	return strings.ReplaceAll(s.Signature, SignatureAgent, agent) + text
+}
+
+// AutoReply returns the reply for a customer message, or "" when no
+// keyword matches. The first matching keyword wins.
+func (s SessionSettings) AutoReply(text string) string {
+	text = strings.ToLower(text)
+	for _, r := range s.AutoReplies {
+		if strings.Contains(text, strings.ToLower(r.Keyword)) {
+			return r.Reply
+		}
+	}
+	return ""
+}
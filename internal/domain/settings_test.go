@@ -0,0 +1,70 @@
+package domain
+
+import "testing"
+
+func TestSessionSettings_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       SessionSettings
+		wantErr bool
+	}{
+		{"zero value", SessionSettings{}, false},
+		{"valid", SessionSettings{
+			Groups:       GroupsSender,
+			ReopenPolicy: ReopenPolicyNew,
+			AutoReplies:  []AutoReply{{Keyword: "hours", Reply: "We're open 9 to 5"}},
+			Media:        MediaSettings{Images: true, MaxBytes: 1 << 20},
+		}, false},
+		{"unknown groups", SessionSettings{Groups: "all"}, true},
+		{"unknown reopen policy", SessionSettings{ReopenPolicy: "never"}, true},
+		{"inactivity", SessionSettings{ReopenPolicy: ReopenPolicyInactivity, InactivityHours: 24}, false},
+		{"inactivity without hours", SessionSettings{ReopenPolicy: ReopenPolicyInactivity}, true},
+		{"empty auto reply", SessionSettings{AutoReplies: []AutoReply{{Keyword: "hi"}}}, true},
+		{"negative max bytes", SessionSettings{Media: MediaSettings{MaxBytes: -1}}, true},
+		{"events", SessionSettings{Events: map[string]bool{EventConnectionUpdate: false, EventMessagesUpsert: true}}, false},
+		{"unknown event", SessionSettings{Events: map[string]bool{"presence.update": true}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.s.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSessionSettings_Sign(t *testing.T) {
+	s := SessionSettings{Signature: "*{agent}:*\n"}
+	if got := s.Sign("Ana", "Hello"); got != "*Ana:*\nHello" {
+		t.Fatalf("Sign() = %q", got)
+	}
+	if got := (SessionSettings{}).Sign("Ana", "Hello"); got != "Hello" {
+		t.Fatalf("Sign() without signature = %q", got)
+	}
+}
+
+func TestSessionSettings_AutoReply(t *testing.T) {
+	s := SessionSettings{AutoReplies: []AutoReply{
+		{Keyword: "Hours", Reply: "9 to 5"},
+		{Keyword: "address", Reply: "Main St"},
+	}}
+	if got := s.AutoReply("what are your HOURS?"); got != "9 to 5" {
+		t.Fatalf("AutoReply() = %q, want 9 to 5", got)
+	}
+	if got := s.AutoReply("thanks"); got != "" {
+		t.Fatalf("AutoReply() = %q, want no reply", got)
+	}
+}
+
+func TestSessionSettings_HandlesEvent(t *testing.T) {
+	if !(SessionSettings{}).HandlesEvent(EventMessagesUpsert) {
+		t.Fatal("events must be handled by default")
+	}
+	s := SessionSettings{Events: map[string]bool{EventConnectionUpdate: false, EventQRCodeUpdated: true}}
+	if s.HandlesEvent(EventConnectionUpdate) {
+		t.Fatal("switched off event handled")
+	}
+	if !s.HandlesEvent(EventQRCodeUpdated) || !s.HandlesEvent(EventMessagesUpsert) {
+		t.Fatal("switched on event not handled")
+	}
+}
@@ -0,0 +1,9 @@
+package dto
+
+type CreateAPIKey struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+	// Optional, an empty scope doesn't limit the key
+	SessionIDs         []string `json:"session_ids"`
+	ChatwootAccountIDs []int32  `json:"chatwoot_account_ids"`
+}
@@ -36,6 +36,8 @@ type CWInbox struct {
 	Name        string  `json:"name"`
 	ChannelType string  `json:"channel_type"`
 	Provider    *string `json:"provider"`
+	// Only set on API channel inboxes
+	WebhookURL string `json:"webhook_url"`
 }
 
 type CWSimpleSender struct {
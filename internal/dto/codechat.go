@@ -75,19 +75,77 @@ type CodechatData struct {
 	KeyID            string                 `json:"keyId"`
 	KeyRemoteJid     string                 `json:"KeyRemoteJid"`
 	KeyFromMe        bool                   `json:"keyFromMe"`
+	KeyParticipant   string                 `json:"keyParticipant"` // Member who sent a group message
 	PushName         string                 `json:"pushName"`
 	MessageType      string                 `json:"messageType"`
 	Content          CodechatMessageContent `json:"content"`
 	MessageTimestamp int                    `json:"messageTimestamp"`
 	InstanceID       int                    `json:"instanceId"`
 	Device           string                 `json:"device"`
+	VerifiedBizName  string                 `json:"verifiedBizName"`
 	IsGroup          bool                   `json:"isGroup"`
 }
 
+const (
+	CodechatEventMessagesUpsert   = "messages.upsert"
+	CodechatEventQRCodeUpdated    = "qrcode.updated"
+	CodechatEventConnectionUpdate = "connection.update"
+)
+
 type CodechatWebhook struct {
 	Event    string           `json:"event"`
 	Instance CodechatInstance `json:"instance"`
-	Data     CodechatData     `json:"data"`
+	// Set on messages.upsert
+	Data CodechatData `json:"data"`
+	// Set on qrcode.updated
+	QRCode *CodechatQRCode `json:"-"`
+	// Set on connection.update
+	Connection *CodechatConnectionUpdate `json:"-"`
+}
+
+type CodechatQRCode struct {
+	Code   string `json:"code"`
+	Base64 string `json:"base64"`
+}
+
+type CodechatConnectionUpdate struct {
+	State        string `json:"state"`
+	StatusReason int    `json:"statusReason"`
+}
+
+// UnmarshalJSON decodes data according to the event, only message events
+// carry a CodechatData.
+func (c *CodechatWebhook) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		Event    string           `json:"event"`
+		Instance CodechatInstance `json:"instance"`
+		Data     json.RawMessage  `json:"data"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	c.Event = aux.Event
+	c.Instance = aux.Instance
+
+	switch aux.Event {
+	case CodechatEventQRCodeUpdated:
+		var d struct {
+			QRCode CodechatQRCode `json:"qrcode"`
+		}
+		if err := json.Unmarshal(aux.Data, &d); err != nil {
+			return err
+		}
+		c.QRCode = &d.QRCode
+	case CodechatEventConnectionUpdate:
+		var d CodechatConnectionUpdate
+		if err := json.Unmarshal(aux.Data, &d); err != nil {
+			return err
+		}
+		c.Connection = &d
+	case CodechatEventMessagesUpsert:
+		return json.Unmarshal(aux.Data, &c.Data)
+	}
+	return nil
 }
 
 func (c *CodechatData) UnmarshalJSON(data []byte) error {
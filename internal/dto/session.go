@@ -1,5 +1,7 @@
 package dto
 
+import "github.com/sdrvirtual/codewoot/internal/domain"
+
 type CreateSession struct {
 	SessionID   *string `json:"session_id"`
 	Description *string `json:"description"`
@@ -7,5 +9,39 @@ type CreateSession struct {
 		InboxID   int    `json:"inbox_id"`
 		AccountID int    `json:"account_id"`
 		Token     string `json:"token"`
+		// Optional, verifies the X-Chatwoot-Signature header when set
+		WebhookHMACSecret *string `json:"webhook_hmac_secret"`
+		// Creates an API inbox for the session instead of using inbox_id
+		CreateInbox   bool  `json:"create_inbox"`
+		InboxAgentIDs []int `json:"inbox_agent_ids"`
+	} `json:"chatwoot"`
+	// Optional, bridges an existing Codechat instance instead of creating one
+	Codechat *ImportInstance         `json:"codechat"`
+	Settings *domain.SessionSettings `json:"settings"`
+}
+
+type ImportInstance struct {
+	Instance string `json:"instance"`
+	Token    string `json:"token"`
+}
+
+// UpdateSession only changes the fields that are set
+type UpdateSession struct {
+	Description *string `json:"description"`
+	Chatwoot    struct {
+		InboxID   *int    `json:"inbox_id"`
+		AccountID *int    `json:"account_id"`
+		Token     *string `json:"token"`
 	} `json:"chatwoot"`
+	// Replaces all the settings
+	Settings *domain.SessionSettings `json:"settings"`
+}
+
+type PairSession struct {
+	Phone string `json:"phone"`
+}
+
+type SendMessage struct {
+	Phone string `json:"phone"`
+	Text  string `json:"text"`
 }
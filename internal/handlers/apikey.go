@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sdrvirtual/codewoot/internal/config"
+	"github.com/sdrvirtual/codewoot/internal/db"
+	"github.com/sdrvirtual/codewoot/internal/dto"
+	"github.com/sdrvirtual/codewoot/internal/services"
+)
+
+type APIKeyResponse struct {
+	ID                 int        `json:"id"`
+	Name               string     `json:"name"`
+	Prefix             string     `json:"prefix"`
+	Permissions        []string   `json:"permissions"`
+	SessionIDs         []string   `json:"session_ids"`
+	ChatwootAccountIDs []int32    `json:"chatwoot_account_ids"`
+	RevokedAt          *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
+
+func (rd *APIKeyResponse) Render(w http.ResponseWriter, r *http.Request) error { return nil }
+
+func newAPIKeyResponse(apiKey db.ApiKey) *APIKeyResponse {
+	resp := &APIKeyResponse{
+		ID:                 int(apiKey.ID),
+		Name:               apiKey.Name,
+		Prefix:             apiKey.Prefix,
+		Permissions:        apiKey.Permissions,
+		SessionIDs:         []string{},
+		ChatwootAccountIDs: apiKey.ChatwootAccountIds,
+		CreatedAt:          apiKey.CreatedAt.Time,
+	}
+	for _, id := range apiKey.SessionIds {
+		resp.SessionIDs = append(resp.SessionIDs, id.String())
+	}
+	if apiKey.RevokedAt.Valid {
+		resp.RevokedAt = &apiKey.RevokedAt.Time
+	}
+	return resp
+}
+
+type CreateAPIKeyResponse struct {
+	APIKeyResponse
+	// Only returned once, it can't be recovered later
+	Key string `json:"key"`
+}
+
+func (rd *CreateAPIKeyResponse) Render(w http.ResponseWriter, r *http.Request) error { return nil }
+
+func CreateAPIKey(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1MB
+
+		var payload dto.CreateAPIKey
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.Render(w, r, dto.NewAPIErrorResponse("invalid payload", err.Error()))
+			return
+		}
+
+		apiKey, key, err := services.NewAPIKeyService(cfg, p).Issue(
+			r.Context(),
+			payload.Name,
+			payload.Permissions,
+			payload.SessionIDs,
+			payload.ChatwootAccountIDs,
+		)
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.Render(w, r, dto.NewAPIErrorResponse("error creating api key", err.Error()))
+			return
+		}
+
+		render.Status(r, http.StatusCreated)
+		render.Render(w, r, &CreateAPIKeyResponse{
+			APIKeyResponse: *newAPIKeyResponse(*apiKey),
+			Key:            key,
+		})
+	}
+}
+
+func ListAPIKeys(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKeys, err := services.NewAPIKeyService(cfg, p).List(r.Context())
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.Render(w, r, dto.NewAPIErrorResponse("error listing api keys", err.Error()))
+			return
+		}
+
+		resp := make([]render.Renderer, 0, len(apiKeys))
+		for _, apiKey := range apiKeys {
+			resp = append(resp, newAPIKeyResponse(apiKey))
+		}
+		render.Status(r, http.StatusOK)
+		render.RenderList(w, r, resp)
+	}
+}
+
+func RevokeAPIKey(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 32)
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.Render(w, r, dto.NewAPIErrorResponse("id is not a number", err.Error()))
+			return
+		}
+
+		err = services.NewAPIKeyService(cfg, p).Revoke(r.Context(), int32(id))
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			render.Status(r, http.StatusNotFound)
+			render.Render(w, r, dto.NewAPIErrorResponse("api key not found", ""))
+			return
+		}
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.Render(w, r, dto.NewAPIErrorResponse("error revoking api key", err.Error()))
+			return
+		}
+		render.Status(r, http.StatusNoContent)
+	}
+}
@@ -2,16 +2,17 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/sdrvirtual/codewoot/internal/config"
 	"github.com/sdrvirtual/codewoot/internal/dto"
 	"github.com/sdrvirtual/codewoot/internal/services"
 )
 
-func ChatwootWebhook(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
+func ChatwootWebhook(cfg *config.Config, registry *services.SessionRegistry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("Content-Type") != "application/json" {
 			http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
@@ -20,11 +21,8 @@ func ChatwootWebhook(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
 
 		r.Body = http.MaxBytesReader(w, r.Body, 2<<20) // 2MB
 
-		var payload dto.ChatwootWebhook
-
-		dec := json.NewDecoder(r.Body)
-
-		if err := dec.Decode(&payload); err != nil {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
 			http.Error(w, "invalid payload:\n"+err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -35,11 +33,30 @@ func ChatwootWebhook(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		relay, err := services.NewRelayService(r.Context(), cfg, p, session)
+		relay, err := services.NewRelayService(r.Context(), cfg, registry, session, webhookSecret(r))
+		if errors.Is(err, services.ErrInvalidWebhookSecret) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if err := relay.VerifyChatwootSignature(
+			r.Header.Get("X-Chatwoot-Signature"),
+			r.Header.Get("X-Chatwoot-Timestamp"),
+			body,
+		); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		var payload dto.ChatwootWebhook
+
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, "invalid payload:\n"+err.Error(), http.StatusBadRequest)
+			return
+		}
 
 		if err := relay.FromChatwoot(payload); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sdrvirtual/codewoot/internal/config"
+	"github.com/sdrvirtual/codewoot/internal/dto"
+	"github.com/sdrvirtual/codewoot/internal/services"
+)
+
+// Comment lines keep idle proxies from closing the event stream
+const sseKeepAlive = 15 * time.Second
+
+// SessionQRCode serves the latest QR code of the session as a PNG. When
+// none was received yet, the instance is connected to get one.
+func SessionQRCode(cfg *config.Config, p *pgxpool.Pool, registry *services.SessionRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dbSession, ok := loadSession(w, r, cfg, p)
+		if !ok {
+			return
+		}
+		events := registry.Events()
+		session := dbSession.SessionID.String()
+
+		ev, ok := events.LatestQRCode(session)
+		if !ok {
+			sessionSvc, err := services.NewSessionService(
+				r.Context(),
+				cfg,
+				p,
+				services.WithInstance(dbSession.CodechatInstcanceToken, dbSession.CodechatInstance),
+			)
+			if err != nil {
+				render.Status(r, http.StatusBadRequest)
+				render.Render(w, r, dto.NewAPIErrorResponse("error creating service", err.Error()))
+				return
+			}
+			base64, err := sessionSvc.ConnectSession()
+			if err != nil {
+				render.Status(r, http.StatusBadRequest)
+				render.Render(w, r, dto.NewAPIErrorResponse("error connecting instance", err.Error()))
+				return
+			}
+			if *base64 == "" {
+				// Codechat doesn't return a QR code for paired instances
+				render.Status(r, http.StatusNotFound)
+				render.Render(w, r, dto.NewAPIErrorResponse("no qr code", "session is already connected"))
+				return
+			}
+			ev = services.SessionEvent{Type: services.SessionEventQRCode, QRCode: *base64}
+			events.Publish(session, ev)
+		}
+
+		png, err := ev.QRCodePNG()
+		if err != nil {
+			render.Status(r, http.StatusBadGateway)
+			render.Render(w, r, dto.NewAPIErrorResponse("invalid qr code", err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		w.Write(png)
+	}
+}
+
+// SessionEvents streams the pairing events of the session as Server-Sent
+// Events, starting with the latest QR code, until the connection is open.
+func SessionEvents(cfg *config.Config, p *pgxpool.Pool, registry *services.SessionRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dbSession, ok := loadSession(w, r, cfg, p)
+		if !ok {
+			return
+		}
+		rc := http.NewResponseController(w)
+		events := registry.Events()
+		session := dbSession.SessionID.String()
+
+		ch, cancel := events.Subscribe(session)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		send := func(ev services.SessionEvent) error {
+			b, err := json.Marshal(ev)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, b); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+
+		if ev, ok := events.LatestQRCode(session); ok {
+			if err := send(ev); err != nil {
+				return
+			}
+		} else if err := rc.Flush(); err != nil {
+			return
+		}
+
+		keepAlive := time.NewTicker(sseKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			case ev, ok := <-ch:
+				if !ok {
+					return
+				}
+				if err := send(ev); err != nil {
+					return
+				}
+				if ev.Type == services.SessionEventConnection && ev.State == services.ConnectionStateOpen {
+					return
+				}
+			}
+		}
+	}
+}
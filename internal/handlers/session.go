@@ -2,16 +2,20 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"net/url"
-	"path"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sdrvirtual/codewoot/internal/auth"
 	"github.com/sdrvirtual/codewoot/internal/config"
 	"github.com/sdrvirtual/codewoot/internal/db"
+	"github.com/sdrvirtual/codewoot/internal/domain"
 	"github.com/sdrvirtual/codewoot/internal/dto"
 	"github.com/sdrvirtual/codewoot/internal/services"
 )
@@ -19,35 +23,119 @@ import (
 type CreateSessionResponse struct {
 	ID                   int    `json:"id"`
 	SessionID            string `json:"session_id"`
-	ChatwootInboxWebhook string `json:"chatwoot_inbox_webhook"`
+	ChatwootAccountID    int    `json:"chatwoot_account_id"`
+	ChatwootInboxID      int    `json:"chatwoot_inbox_id"`
+	ChatwootInboxWebhook string `json:"chatwoot_inbox_webhook,omitempty"`
 }
 
 func (rd *CreateSessionResponse) Render(w http.ResponseWriter, r *http.Request) error { return nil }
 
 func newCreateSessionResponse(cfg *config.Config, session db.CodechatSession) *CreateSessionResponse {
-	u, _ := url.Parse(cfg.Server.URL)
-	u.Path = path.Join(u.Path, "/chatwoot/webhook/", session.SessionID.String())
+	u, _ := services.ChatwootWebhookURL(cfg, session)
 	return &CreateSessionResponse{
 		ID:                   int(session.ID),
 		SessionID:            session.SessionID.String(),
-		ChatwootInboxWebhook: u.String(),
+		ChatwootAccountID:    int(session.ChatwootAccountID),
+		ChatwootInboxID:      int(session.ChatwootInboxID),
+		ChatwootInboxWebhook: u,
 	}
 }
 
 type StatusSessionResponse struct {
 	CreateSessionResponse
-	Status string `json:"status"`
+	Status               string `json:"status,omitempty"`
+	ChatwootTokenInvalid bool   `json:"chatwoot_token_invalid"`
+	Broken               bool   `json:"broken"`
+	BrokenReason         string `json:"broken_reason,omitempty"`
+	// Why the inbox webhook couldn't be pointed back at the relay
+	WebhookError string `json:"webhook_error,omitempty"`
+	// Last settled connection state received from Codechat, open or close
+	ConnectionState   string                     `json:"connection_state,omitempty"`
+	ConnectionStateAt *time.Time                 `json:"connection_state_at,omitempty"`
+	ConnectionHistory []*ConnectionEventResponse `json:"connection_history,omitempty"`
+	Settings          domain.SessionSettings     `json:"settings"`
 }
 
+type ConnectionEventResponse struct {
+	State        string    `json:"state"`
+	StatusReason int       `json:"status_reason,omitempty"`
+	At           time.Time `json:"at"`
+}
+
+func newConnectionHistory(events []db.ConnectionEvent) []*ConnectionEventResponse {
+	history := make([]*ConnectionEventResponse, 0, len(events))
+	for _, ev := range events {
+		history = append(history, &ConnectionEventResponse{
+			State:        ev.State,
+			StatusReason: int(ev.StatusReason),
+			At:           ev.CreatedAt.Time,
+		})
+	}
+	return history
+}
+
+// Connection events returned by StatusSession
+const connectionHistoryLimit = 20
+
 func (rd *StatusSessionResponse) Render(w http.ResponseWriter, r *http.Request) error { return nil }
 
 func newStatusSessionResponse(cfg *config.Config, session db.CodechatSession, status string) *StatusSessionResponse {
-	return &StatusSessionResponse{
+	resp := &StatusSessionResponse{
 		CreateSessionResponse: *newCreateSessionResponse(cfg, session),
 		Status:                status,
+		ChatwootTokenInvalid:  session.ChatwootTokenInvalid,
+		WebhookError:          session.WebhookError,
+		ConnectionState:       session.ConnectionState,
+		Settings:              session.Settings,
+	}
+	if session.ConnectionStateAt.Valid {
+		resp.ConnectionStateAt = &session.ConnectionStateAt.Time
 	}
+	return resp
 }
 
+func newBrokenSessionResponse(cfg *config.Config, session db.CodechatSession, reason error) *StatusSessionResponse {
+	resp := newStatusSessionResponse(cfg, session, "broken")
+	resp.Broken = true
+	resp.BrokenReason = reason.Error()
+	return resp
+}
+
+type SessionListItemResponse struct {
+	StatusSessionResponse
+	Description string `json:"description,omitempty"`
+	StatusError string `json:"status_error,omitempty"`
+}
+
+func newSessionListItemResponse(cfg *config.Config, item services.SessionListItem) *SessionListItemResponse {
+	resp := &SessionListItemResponse{
+		Description: item.Session.Description,
+	}
+	if item.Broken != nil {
+		resp.StatusSessionResponse = *newBrokenSessionResponse(cfg, item.Session, item.Broken)
+	} else {
+		resp.StatusSessionResponse = *newStatusSessionResponse(cfg, item.Session, item.Status)
+	}
+	if item.StatusErr != nil {
+		resp.StatusError = item.StatusErr.Error()
+	}
+	return resp
+}
+
+type ListSessionsResponse struct {
+	Sessions   []*SessionListItemResponse `json:"sessions"`
+	Limit      int                        `json:"limit"`
+	Offset     int                        `json:"offset"`
+	NextOffset *int                       `json:"next_offset,omitempty"`
+}
+
+func (rd *ListSessionsResponse) Render(w http.ResponseWriter, r *http.Request) error { return nil }
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
 type ConnectSessionResponse struct {
 	Base64 string `json:"base64"`
 }
@@ -60,6 +148,54 @@ func newConnectSessionResponse(base64 string) *ConnectSessionResponse {
 	}
 }
 
+// hideWebhookSecret drops the inbox webhook, which carries the session
+// webhook secret, from responses to keys that can only read sessions.
+func hideWebhookSecret(r *http.Request, resp *StatusSessionResponse) {
+	if principal := auth.FromContext(r.Context()); principal == nil || !principal.Can(auth.PermManageSessions) {
+		resp.ChatwootInboxWebhook = ""
+	}
+}
+
+// canAccessSession hides sessions outside of the API key scope, answering as
+// if they didn't exist.
+func canAccessSession(w http.ResponseWriter, r *http.Request, session db.CodechatSession) bool {
+	if principal := auth.FromContext(r.Context()); principal != nil &&
+		!principal.CanAccess(session.SessionID.String(), session.ChatwootAccountID) {
+		render.Status(r, http.StatusNotFound)
+		render.Render(w, r, dto.NewAPIErrorResponse("session not found", ""))
+		return false
+	}
+	return true
+}
+
+// loadSession reads the session of the {session} path param, answering the
+// request itself when it can't be used.
+func loadSession(w http.ResponseWriter, r *http.Request, cfg *config.Config, p *pgxpool.Pool) (db.CodechatSession, bool) {
+	var sessionUUID pgtype.UUID
+	if err := sessionUUID.Scan(chi.URLParam(r, "session")); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.Render(w, r, dto.NewAPIErrorResponse("session is not a uuid", err.Error()))
+		return db.CodechatSession{}, false
+	}
+
+	q := db.NewStore(p, cfg.Encryption.Keys)
+	dbSession, err := q.GetSessionBySessionId(r.Context(), sessionUUID)
+	if err != nil && err.Error() == "no rows in result set" {
+		render.Status(r, http.StatusNotFound)
+		render.Render(w, r, dto.NewAPIErrorResponse("session not found", ""))
+		return db.CodechatSession{}, false
+	}
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.Render(w, r, dto.NewAPIErrorResponse("Error getting session", err.Error()))
+		return db.CodechatSession{}, false
+	}
+	if !canAccessSession(w, r, dbSession) {
+		return db.CodechatSession{}, false
+	}
+	return dbSession, true
+}
+
 func CreateSession(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		r.Body = http.MaxBytesReader(w, r.Body, 2<<20) // 2MB
@@ -74,6 +210,18 @@ func CreateSession(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
+		if principal := auth.FromContext(r.Context()); principal != nil {
+			var sessionID string
+			if payload.SessionID != nil {
+				sessionID = *payload.SessionID
+			}
+			if !principal.CanAccess(sessionID, int32(payload.Chatwoot.AccountID)) {
+				render.Status(r, http.StatusForbidden)
+				render.Render(w, r, dto.NewAPIErrorResponse("forbidden", "session is outside of the api key scope"))
+				return
+			}
+		}
+
 		sessionService, err := services.NewSessionService(r.Context(), cfg, p)
 		if err != nil {
 			render.Status(r, http.StatusBadRequest)
@@ -81,42 +229,237 @@ func CreateSession(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		session, err := sessionService.CreateSession(
-			payload.SessionID,
-			payload.Description,
-			payload.Chatwoot.Token,
-			payload.Chatwoot.InboxID,
-			payload.Chatwoot.AccountID,
-		)
+		session, err := sessionService.CreateSession(payload)
 		if err != nil {
+			if errors.Is(err, services.ErrInstanceTaken) {
+				render.Status(r, http.StatusConflict)
+				render.Render(w, r, dto.NewAPIErrorResponse("error creating session", err.Error()))
+				return
+			}
 			render.Status(r, http.StatusBadRequest)
 			render.Render(w, r, dto.NewAPIErrorResponse("error creating session", err.Error()))
 			return
 		}
 
-		sessionService, err = services.NewSessionService(
+		render.Status(r, http.StatusCreated)
+		render.Render(w, r, newCreateSessionResponse(cfg, *session))
+	}
+}
+
+// queryInt reads an optional non negative integer query param.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non negative integer", name)
+	}
+	return n, nil
+}
+
+type PairSessionResponse struct {
+	PairingCode string `json:"pairing_code"`
+}
+
+func (rd *PairSessionResponse) Render(w http.ResponseWriter, r *http.Request) error { return nil }
+
+func PairSession(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1MB
+
+		dbSession, ok := loadSession(w, r, cfg, p)
+		if !ok {
+			return
+		}
+
+		var payload dto.PairSession
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.Render(w, r, dto.NewAPIErrorResponse("invalid payload", err.Error()))
+			return
+		}
+		if payload.Phone == "" {
+			render.Status(r, http.StatusBadRequest)
+			render.Render(w, r, dto.NewAPIErrorResponse("invalid payload", "phone is required"))
+			return
+		}
+
+		sessionSvc, err := services.NewSessionService(
 			r.Context(),
 			cfg,
 			p,
-			services.WithInstance(session.CodechatInstcanceToken, session.CodechatInstance),
+			services.WithInstance(dbSession.CodechatInstcanceToken, dbSession.CodechatInstance),
 		)
 		if err != nil {
 			render.Status(r, http.StatusBadRequest)
 			render.Render(w, r, dto.NewAPIErrorResponse("error creating service", err.Error()))
 			return
 		}
-		if err = sessionService.SetWebhook(session.SessionID.String()); err != nil {
+		code, err := sessionSvc.PairSession(payload.Phone)
+		if err != nil {
 			render.Status(r, http.StatusBadRequest)
-			render.Render(w, r, dto.NewAPIErrorResponse("error configuring webhook", err.Error()))
+			render.Render(w, r, dto.NewAPIErrorResponse("error pairing instance", err.Error()))
 			return
 		}
 
-		render.Status(r, http.StatusCreated)
-		render.Render(w, r, newCreateSessionResponse(cfg, *session))
+		render.Status(r, http.StatusOK)
+		render.Render(w, r, &PairSessionResponse{PairingCode: code})
+	}
+}
+
+type SendMessageResponse struct {
+	MessageID string `json:"message_id"`
+}
+
+func (rd *SendMessageResponse) Render(w http.ResponseWriter, r *http.Request) error { return nil }
+
+func SendMessage(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1MB
+
+		dbSession, ok := loadSession(w, r, cfg, p)
+		if !ok {
+			return
+		}
+
+		var payload dto.SendMessage
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.Render(w, r, dto.NewAPIErrorResponse("invalid payload", err.Error()))
+			return
+		}
+		if payload.Phone == "" || payload.Text == "" {
+			render.Status(r, http.StatusBadRequest)
+			render.Render(w, r, dto.NewAPIErrorResponse("invalid payload", "phone and text are required"))
+			return
+		}
+
+		sessionSvc, err := services.NewSessionService(
+			r.Context(),
+			cfg,
+			p,
+			services.WithInstance(dbSession.CodechatInstcanceToken, dbSession.CodechatInstance),
+		)
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.Render(w, r, dto.NewAPIErrorResponse("error creating service", err.Error()))
+			return
+		}
+		id, err := sessionSvc.SendText(payload.Phone, payload.Text)
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.Render(w, r, dto.NewAPIErrorResponse("error sending message", err.Error()))
+			return
+		}
+
+		render.Status(r, http.StatusOK)
+		render.Render(w, r, &SendMessageResponse{MessageID: id})
 	}
 }
 
-func ConnectSession(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
+func RestartSession(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
+	return sessionInstanceAction(cfg, p, "error restarting instance", (*services.SessionService).RestartSession)
+}
+
+func LogoutSession(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
+	return sessionInstanceAction(cfg, p, "error logging out instance", (*services.SessionService).LogoutSession)
+}
+
+// sessionInstanceAction runs an action on the Codechat instance of the
+// session, leaving the session itself as it is.
+func sessionInstanceAction(cfg *config.Config, p *pgxpool.Pool, errMsg string, action func(*services.SessionService, db.CodechatSession) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dbSession, ok := loadSession(w, r, cfg, p)
+		if !ok {
+			return
+		}
+
+		sessionSvc, err := services.NewSessionService(
+			r.Context(),
+			cfg,
+			p,
+			services.WithInstance(dbSession.CodechatInstcanceToken, dbSession.CodechatInstance),
+		)
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.Render(w, r, dto.NewAPIErrorResponse("error creating service", err.Error()))
+			return
+		}
+		if err := action(sessionSvc, dbSession); err != nil {
+			render.Status(r, http.StatusBadGateway)
+			render.Render(w, r, dto.NewAPIErrorResponse(errMsg, err.Error()))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func ListSessions(cfg *config.Config, p *pgxpool.Pool, registry *services.SessionRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var filter services.SessionFilter
+		params := []struct {
+			name string
+			def  int
+			dst  *int32
+		}{
+			{"account_id", 0, &filter.ChatwootAccountID},
+			{"inbox_id", 0, &filter.ChatwootInboxID},
+			{"limit", defaultListLimit, &filter.Limit},
+			{"offset", 0, &filter.Offset},
+		}
+		for _, param := range params {
+			n, err := queryInt(r, param.name, param.def)
+			if err != nil {
+				render.Status(r, http.StatusBadRequest)
+				render.Render(w, r, dto.NewAPIErrorResponse("invalid query param", err.Error()))
+				return
+			}
+			*param.dst = int32(n)
+		}
+		if filter.Limit == 0 {
+			filter.Limit = defaultListLimit
+		}
+		filter.Limit = min(filter.Limit, maxListLimit)
+		filter.Status = r.URL.Query().Get("status")
+		filter.Live = r.URL.Query().Get("live") == "true"
+		filter.Principal = auth.FromContext(r.Context())
+
+		sessionSvc, err := services.NewSessionService(r.Context(), cfg, p, services.WithRegistry(registry))
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.Render(w, r, dto.NewAPIErrorResponse("error creating service", err.Error()))
+			return
+		}
+		items, hasMore, err := sessionSvc.ListSessions(filter)
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.Render(w, r, dto.NewAPIErrorResponse("Error listing sessions", err.Error()))
+			return
+		}
+
+		resp := &ListSessionsResponse{
+			Sessions: make([]*SessionListItemResponse, 0, len(items)),
+			Limit:    int(filter.Limit),
+			Offset:   int(filter.Offset),
+		}
+		for _, item := range items {
+			itemResp := newSessionListItemResponse(cfg, item)
+			hideWebhookSecret(r, &itemResp.StatusSessionResponse)
+			resp.Sessions = append(resp.Sessions, itemResp)
+		}
+		if hasMore {
+			next := int(filter.Offset) + len(items)
+			resp.NextOffset = &next
+		}
+		render.Status(r, http.StatusOK)
+		render.Render(w, r, resp)
+	}
+}
+
+func ConnectSession(cfg *config.Config, p *pgxpool.Pool, registry *services.SessionRegistry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		session := chi.URLParam(r, "session")
 
@@ -134,11 +477,15 @@ func ConnectSession(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		q := db.New(p)
+		q := db.NewStore(p, cfg.Encryption.Keys)
 		dbSession, err := q.GetSessionBySessionId(r.Context(), sessionUUID)
 		if err != nil {
 			render.Status(r, http.StatusBadRequest)
 			render.Render(w, r, dto.NewAPIErrorResponse("Error getting session", err.Error()))
+			return
+		}
+		if !canAccessSession(w, r, dbSession) {
+			return
 		}
 
 		sessionSvc, err := services.NewSessionService(
@@ -158,6 +505,12 @@ func ConnectSession(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
 			render.Render(w, r, dto.NewAPIErrorResponse("error connecting instance", err.Error()))
 			return
 		}
+		if *base64 != "" {
+			registry.Events().Publish(dbSession.SessionID.String(), services.SessionEvent{
+				Type:   services.SessionEventQRCode,
+				QRCode: *base64,
+			})
+		}
 
 		render.Status(r, http.StatusOK)
 		render.Render(w, r, newConnectSessionResponse(*base64))
@@ -182,7 +535,7 @@ func StatusSession(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		q := db.New(p)
+		q := db.NewStore(p, cfg.Encryption.Keys)
 		dbSession, err := q.GetSessionBySessionId(r.Context(), sessionUUID)
 		if err != nil && err.Error() == "no rows in result set" {
 			render.Status(r, http.StatusNotFound)
@@ -194,6 +547,29 @@ func StatusSession(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
 			render.Render(w, r, dto.NewAPIErrorResponse("Error getting session", err.Error()))
 			return
 		}
+		if !canAccessSession(w, r, dbSession) {
+			return
+		}
+
+		events, err := q.ListConnectionEvents(r.Context(), db.ListConnectionEventsParams{
+			SessionID: dbSession.SessionID,
+			Limit:     connectionHistoryLimit,
+		})
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.Render(w, r, dto.NewAPIErrorResponse("Error getting connection history", err.Error()))
+			return
+		}
+		history := newConnectionHistory(events)
+
+		if err := services.ValidateSession(cfg, dbSession); err != nil {
+			resp := newBrokenSessionResponse(cfg, dbSession, err)
+			resp.ConnectionHistory = history
+			hideWebhookSecret(r, resp)
+			render.Status(r, http.StatusOK)
+			render.Render(w, r, resp)
+			return
+		}
 
 		sessionSvc, err := services.NewSessionService(
 			r.Context(),
@@ -213,16 +589,15 @@ func StatusSession(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
 			render.Render(w, r, dto.NewAPIErrorResponse("Error fetching instance", err.Error()))
 			return
 		}
+		status := newStatusSessionResponse(cfg, dbSession, resp.ConnectionStatus)
+		status.ConnectionHistory = history
+		hideWebhookSecret(r, status)
 		render.Status(r, http.StatusOK)
-		render.Render(
-			w,
-			r,
-			newStatusSessionResponse(cfg, dbSession, resp.ConnectionStatus),
-		)
+		render.Render(w, r, status)
 	}
 }
 
-func DeleteSession(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
+func DeleteSession(cfg *config.Config, p *pgxpool.Pool, registry *services.SessionRegistry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		session := chi.URLParam(r, "session")
 
@@ -240,7 +615,7 @@ func DeleteSession(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		q := db.New(p)
+		q := db.NewStore(p, cfg.Encryption.Keys)
 		dbSession, err := q.GetSessionBySessionId(r.Context(), sessionUUID)
 		if err != nil && err.Error() == "no rows in result set" {
 			render.Status(r, http.StatusNotFound)
@@ -252,6 +627,9 @@ func DeleteSession(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
 			render.Render(w, r, dto.NewAPIErrorResponse("Error getting session", err.Error()))
 			return
 		}
+		if !canAccessSession(w, r, dbSession) {
+			return
+		}
 
 		sessionSvc, err := services.NewSessionService(
 			r.Context(),
@@ -265,7 +643,7 @@ func DeleteSession(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		err = sessionSvc.DeleteInstance()
+		err = sessionSvc.DeleteInstance(dbSession)
 		if err != nil {
 			render.Status(r, http.StatusBadRequest)
 			render.Render(w, r, dto.NewAPIErrorResponse("Error deleting instance", err.Error()))
@@ -278,6 +656,57 @@ func DeleteSession(cfg *config.Config, p *pgxpool.Pool) http.HandlerFunc {
 			render.Render(w, r, dto.NewAPIErrorResponse("Error deleting instance", err.Error()))
 			return
 		}
+		registry.Invalidate(session)
 		render.Status(r, http.StatusNoContent)
 	}
 }
+
+func UpdateSession(cfg *config.Config, p *pgxpool.Pool, registry *services.SessionRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, 2<<20) // 2MB
+
+		dbSession, ok := loadSession(w, r, cfg, p)
+		if !ok {
+			return
+		}
+
+		var payload dto.UpdateSession
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.Render(w, r, dto.NewAPIErrorResponse("invalid payload", err.Error()))
+			return
+		}
+		// Moving the session into an account needs rights over that
+		// account, access to the session alone isn't enough
+		if principal := auth.FromContext(r.Context()); principal != nil && payload.Chatwoot.AccountID != nil &&
+			int32(*payload.Chatwoot.AccountID) != dbSession.ChatwootAccountID &&
+			!principal.CanAccessAccount(int32(*payload.Chatwoot.AccountID)) {
+			render.Status(r, http.StatusForbidden)
+			render.Render(w, r, dto.NewAPIErrorResponse("forbidden", "account is outside of the api key scope"))
+			return
+		}
+
+		sessionSvc, err := services.NewSessionService(r.Context(), cfg, p)
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.Render(w, r, dto.NewAPIErrorResponse("error creating service", err.Error()))
+			return
+		}
+		updated, err := sessionSvc.UpdateSession(dbSession, services.SessionUpdate{
+			Description:       payload.Description,
+			ChatwootToken:     payload.Chatwoot.Token,
+			ChatwootAccountID: payload.Chatwoot.AccountID,
+			ChatwootInboxID:   payload.Chatwoot.InboxID,
+			Settings:          payload.Settings,
+		})
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.Render(w, r, dto.NewAPIErrorResponse("error updating session", err.Error()))
+			return
+		}
+		registry.Invalidate(updated.SessionID.String())
+
+		render.Status(r, http.StatusOK)
+		render.Render(w, r, newSessionListItemResponse(cfg, services.SessionListItem{Session: *updated}))
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/sdrvirtual/codewoot/internal/services"
+)
+
+type webhookSecretKey struct{}
+
+// StripWebhookSecret moves the webhook secret from the query string into
+// the request context, so it doesn't end up in the access logs.
+func StripWebhookSecret(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		secret := q.Get(services.WebhookSecretParam)
+		if secret == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		q.Del(services.WebhookSecretParam)
+		r.URL.RawQuery = q.Encode()
+		r.RequestURI = r.URL.RequestURI()
+		ctx := context.WithValue(r.Context(), webhookSecretKey{}, secret)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func webhookSecret(r *http.Request) string {
+	secret, _ := r.Context().Value(webhookSecretKey{}).(string)
+	return secret
+}
@@ -0,0 +1,163 @@
+// Package secrets encrypts values stored at rest.
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Encrypted values look like "enc:v1:<key id>:<base64(nonce|ciphertext)>".
+// Anything without the prefix is treated as legacy plaintext, so rows
+// written before encryption was enabled keep working until re-encrypted.
+const prefix = "enc:v1:"
+
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// Keyring holds the AES-GCM keys by ID. New values are always encrypted
+// with the primary key, the others are only kept to decrypt old values.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring parses a comma separated list of "<id>:<base64 key>" pairs,
+// the first one being the primary key. Keys must be 16, 24 or 32 bytes
+// long. An empty spec returns a keyring that stores values in plaintext.
+func NewKeyring(spec string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(part, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid key %q, expected <id>:<base64 key>", part)
+		}
+		if _, exists := k.keys[id]; exists {
+			return nil, fmt.Errorf("duplicated key id %q", id)
+		}
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		k.keys[id] = aead
+		if k.primary == "" {
+			k.primary = id
+		}
+	}
+	return k, nil
+}
+
+func (k *Keyring) Enabled() bool {
+	return k != nil && k.primary != ""
+}
+
+func (k *Keyring) PrimaryKeyID() string {
+	if k == nil {
+		return ""
+	}
+	return k.primary
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// KeyID returns the ID of the key a value was encrypted with, or "" for
+// plaintext values.
+func KeyID(value string) string {
+	if !IsEncrypted(value) {
+		return ""
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	return id
+}
+
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if !k.Enabled() || plaintext == "" {
+		return plaintext, nil
+	}
+	aead := k.keys[k.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return prefix + k.primary + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	if !ok {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	if k == nil {
+		return "", fmt.Errorf("%w %q: no keys configured", ErrUnknownKey, id)
+	}
+	aead, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value: %w", err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypt with key %q: %w", id, err)
+	}
+	return string(plaintext), nil
+}
+
+// Rotate re-encrypts value with the primary key. The boolean reports
+// whether the value changed, values already under the primary key are
+// returned as they are.
+func (k *Keyring) Rotate(value string) (string, bool, error) {
+	if !k.Enabled() || value == "" || KeyID(value) == k.primary {
+		return value, false, nil
+	}
+	plaintext, err := k.Decrypt(value)
+	if err != nil {
+		return "", false, err
+	}
+	out, err := k.Encrypt(plaintext)
+	if err != nil {
+		return "", false, err
+	}
+	return out, true, nil
+}
+
+type keyringKey struct{}
+
+// NewContext carries the keyring to code that can't receive it directly,
+// like the goose migrations.
+func NewContext(ctx context.Context, k *Keyring) context.Context {
+	return context.WithValue(ctx, keyringKey{}, k)
+}
+
+func FromContext(ctx context.Context) *Keyring {
+	k, _ := ctx.Value(keyringKey{}).(*Keyring)
+	return k
+}
@@ -0,0 +1,100 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func key(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), 32)))
+}
+
+func TestKeyring_EncryptDecrypt(t *testing.T) {
+	k, err := NewKeyring("k1:" + key('a'))
+	if err != nil {
+		t.Fatalf("NewKeyring error: %v", err)
+	}
+
+	enc, err := k.Encrypt("token")
+	if err != nil {
+		t.Fatalf("Encrypt error: %v", err)
+	}
+	if !IsEncrypted(enc) || KeyID(enc) != "k1" {
+		t.Fatalf("unexpected encrypted value: %q", enc)
+	}
+	if strings.Contains(enc, "token") {
+		t.Fatalf("encrypted value leaks plaintext: %q", enc)
+	}
+
+	dec, err := k.Decrypt(enc)
+	if err != nil {
+		t.Fatalf("Decrypt error: %v", err)
+	}
+	if dec != "token" {
+		t.Fatalf("got %q, want %q", dec, "token")
+	}
+}
+
+func TestKeyring_PlaintextPassthrough(t *testing.T) {
+	k, err := NewKeyring("")
+	if err != nil {
+		t.Fatalf("NewKeyring error: %v", err)
+	}
+	if k.Enabled() {
+		t.Fatalf("expected empty keyring to be disabled")
+	}
+	enc, _ := k.Encrypt("token")
+	if enc != "token" {
+		t.Fatalf("expected plaintext when disabled, got %q", enc)
+	}
+	dec, err := k.Decrypt("legacy")
+	if err != nil || dec != "legacy" {
+		t.Fatalf("expected legacy plaintext to pass through, got %q err=%v", dec, err)
+	}
+}
+
+func TestKeyring_Rotate(t *testing.T) {
+	old, _ := NewKeyring("k1:" + key('a'))
+	enc, _ := old.Encrypt("token")
+
+	k, err := NewKeyring("k2:" + key('b') + ",k1:" + key('a'))
+	if err != nil {
+		t.Fatalf("NewKeyring error: %v", err)
+	}
+
+	for _, in := range []string{enc, "token"} {
+		rotated, changed, err := k.Rotate(in)
+		if err != nil {
+			t.Fatalf("Rotate error: %v", err)
+		}
+		if !changed || KeyID(rotated) != "k2" {
+			t.Fatalf("expected value to be re-encrypted with k2, got %q", rotated)
+		}
+		if dec, _ := k.Decrypt(rotated); dec != "token" {
+			t.Fatalf("got %q after rotation", dec)
+		}
+		if _, changed, _ := k.Rotate(rotated); changed {
+			t.Fatalf("expected value under the primary key to be left alone")
+		}
+	}
+}
+
+func TestKeyring_UnknownKey(t *testing.T) {
+	old, _ := NewKeyring("k1:" + key('a'))
+	enc, _ := old.Encrypt("token")
+
+	k, _ := NewKeyring("k2:" + key('b'))
+	if _, err := k.Decrypt(enc); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected ErrUnknownKey, got %v", err)
+	}
+}
+
+func TestNewKeyring_Invalid(t *testing.T) {
+	for _, spec := range []string{"nokey", "k1:not-base64!", "k1:" + base64.StdEncoding.EncodeToString([]byte("short")), "k1:" + key('a') + ",k1:" + key('b')} {
+		if _, err := NewKeyring(spec); err == nil {
+			t.Fatalf("expected error for %q", spec)
+		}
+	}
+}
@@ -4,17 +4,21 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sdrvirtual/codewoot/internal/auth"
 	"github.com/sdrvirtual/codewoot/internal/config"
 	"github.com/sdrvirtual/codewoot/internal/dto"
 	"github.com/sdrvirtual/codewoot/internal/handlers"
+	"github.com/sdrvirtual/codewoot/internal/services"
 )
 
 type bodyCaptureResponseWriter struct {
@@ -28,12 +32,26 @@ func (w *bodyCaptureResponseWriter) WriteHeader(code int) {
 	w.ResponseWriter.WriteHeader(code)
 }
 
+// Only the start of a response body is kept for the error log
+const maxCapturedBody = 64 << 10
+
 func (w *bodyCaptureResponseWriter) Write(b []byte) (int, error) {
-	// Copy response body to the buffer
-	_, _ = w.body.Write(b)
+	// Copy response body to the buffer, streams like the session events
+	// aren't kept at all
+	if room := maxCapturedBody - w.body.Len(); room > 0 && !strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		_, _ = w.body.Write(b[:min(len(b), room)])
+	}
 	return w.ResponseWriter.Write(b)
 }
 
+// Flush lets streaming handlers, like the session events, through the
+// capture.
+func (w *bodyCaptureResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func formatMaybeJSON(b []byte) string {
 	if len(b) == 0 {
 		return ""
@@ -72,38 +90,89 @@ func errorLogger(next http.Handler) http.Handler {
 	})
 }
 
-func authMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+func authMiddleware(cfg *config.Config, p *pgxpool.Pool) func(http.Handler) http.Handler {
+	apiKeys := services.NewAPIKeyService(cfg, p)
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.Header.Get("Api-Key") == "" {
+			key := r.Header.Get("Api-Key")
+			if key == "" {
 				render.Status(r, http.StatusUnauthorized)
 				render.Render(w, r, dto.NewAPIErrorResponse("not authorized", "missing Api-Key header"))
 				return
 			}
-			if r.Header.Get("Api-Key") != cfg.Authorization.Key {
+			principal, err := apiKeys.Authenticate(r.Context(), key)
+			if errors.Is(err, services.ErrInvalidAPIKey) {
 				render.Status(r, http.StatusUnauthorized)
 				render.Render(w, r, dto.NewAPIErrorResponse("not authorized", "invalid token"))
 				return
 			}
+			if err != nil {
+				render.Status(r, http.StatusInternalServerError)
+				render.Render(w, r, dto.NewAPIErrorResponse("error checking api key", err.Error()))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), principal)))
+		})
+	}
+}
+
+func requirePermission(perm auth.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if principal := auth.FromContext(r.Context()); principal == nil || !principal.Can(perm) {
+				render.Status(r, http.StatusForbidden)
+				render.Render(w, r, dto.NewAPIErrorResponse("forbidden", "api key lacks "+string(perm)))
+				return
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-func SessionRouter(cfg *config.Config, p *pgxpool.Pool) http.Handler {
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal := auth.FromContext(r.Context()); principal == nil || !principal.Admin {
+			render.Status(r, http.StatusForbidden)
+			render.Render(w, r, dto.NewAPIErrorResponse("forbidden", "admin api key required"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func SessionRouter(cfg *config.Config, p *pgxpool.Pool, registry *services.SessionRegistry) http.Handler {
 	r := chi.NewRouter()
-	r.Use(authMiddleware(cfg))
-	r.Post("/", handlers.CreateSession(cfg, p))
+	r.Use(authMiddleware(cfg, p))
+	r.With(requirePermission(auth.PermReadSessions)).Get("/", handlers.ListSessions(cfg, p, registry))
+	r.With(requirePermission(auth.PermManageSessions)).Post("/", handlers.CreateSession(cfg, p))
 	r.Route("/{session}", func(r chi.Router) {
-		r.Get("/", handlers.StatusSession(cfg, p))
-		r.Delete("/", handlers.DeleteSession(cfg, p))
-		r.Post("/connect", handlers.ConnectSession(cfg, p))
+		r.With(requirePermission(auth.PermReadSessions)).Get("/", handlers.StatusSession(cfg, p))
+		r.With(requirePermission(auth.PermManageSessions)).Patch("/", handlers.UpdateSession(cfg, p, registry))
+		r.With(requirePermission(auth.PermManageSessions)).Delete("/", handlers.DeleteSession(cfg, p, registry))
+		r.With(requirePermission(auth.PermManageSessions)).Post("/connect", handlers.ConnectSession(cfg, p, registry))
+		r.With(requirePermission(auth.PermManageSessions)).Post("/pair", handlers.PairSession(cfg, p))
+		r.With(requirePermission(auth.PermSendMessages)).Post("/messages", handlers.SendMessage(cfg, p))
+		r.With(requirePermission(auth.PermManageSessions)).Post("/restart", handlers.RestartSession(cfg, p))
+		r.With(requirePermission(auth.PermManageSessions)).Post("/logout", handlers.LogoutSession(cfg, p))
+		r.With(requirePermission(auth.PermManageSessions)).Get("/qr.png", handlers.SessionQRCode(cfg, p, registry))
+		r.With(requirePermission(auth.PermManageSessions)).Get("/events", handlers.SessionEvents(cfg, p, registry))
 	})
 	return r
 }
 
-func New(cfg *config.Config, p *pgxpool.Pool) *http.Server {
+func APIKeyRouter(cfg *config.Config, p *pgxpool.Pool) http.Handler {
+	r := chi.NewRouter()
+	r.Use(authMiddleware(cfg, p))
+	r.Use(requireAdmin)
+	r.Post("/", handlers.CreateAPIKey(cfg, p))
+	r.Get("/", handlers.ListAPIKeys(cfg, p))
+	r.Delete("/{id}", handlers.RevokeAPIKey(cfg, p))
+	return r
+}
+
+func New(cfg *config.Config, p *pgxpool.Pool, workers *services.Workers) *http.Server {
 	r := chi.NewRouter()
+	r.Use(handlers.StripWebhookSecret)
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
 	r.Use(errorLogger)
@@ -111,24 +180,33 @@ func New(cfg *config.Config, p *pgxpool.Pool) *http.Server {
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.StripSlashes)
 
+	registry := services.NewSessionRegistry(cfg, p, workers)
+	workers.Go(registry.Run)
+	workers.Go(registry.Outbound().Run)
+	workers.Go(services.NewWebhookReconciler(registry).Run)
+
 	r.Get("/health", handlers.Health)
 
-	r.Mount("/session", SessionRouter(cfg, p))
+	r.Mount("/session", SessionRouter(cfg, p, registry))
+	r.Mount("/api-key", APIKeyRouter(cfg, p))
 
 	// chatwoot -> codewoot -> codechat
 	r.Route("/chatwoot", func(r chi.Router) {
-		r.Post("/webhook/{session}", handlers.ChatwootWebhook(cfg, p))
+		r.Post("/webhook/{session}", handlers.ChatwootWebhook(cfg, registry))
 	})
 
 	// codechat -> codewoot -> chatwoot
 	r.Route("/codechat", func(r chi.Router) {
-		r.Post("/webhook/{session}", handlers.CodechatWebhook(cfg, p))
+		r.Post("/webhook/{session}", handlers.CodechatWebhook(cfg, registry))
 	})
 
 	// TODO: CORS, Auth, Middleware contexto do request (instancia, etc..)
 	addr := cfg.Server.Host + ":" + cfg.Server.Port
-	return &http.Server{
+	srv := &http.Server{
 		Addr:    addr,
 		Handler: r,
 	}
+	// Shutdown waits for active requests, end the event streams first
+	srv.RegisterOnShutdown(registry.Events().Close)
+	return srv
 }
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyCaptureResponseWriter(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"json", "application/json", `{"error":"bad"}`, len(`{"error":"bad"}`)},
+		{"large", "text/plain", strings.Repeat("x", 2*maxCapturedBody), maxCapturedBody},
+		{"event stream", "text/event-stream", "event: qrcode\ndata: {}\n\n", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			bw := &bodyCaptureResponseWriter{ResponseWriter: rec, status: http.StatusOK}
+			bw.Header().Set("Content-Type", tt.contentType)
+			if _, err := bw.Write([]byte(tt.body)); err != nil {
+				t.Fatalf("Write() error: %v", err)
+			}
+			if rec.Body.String() != tt.body {
+				t.Fatal("body not passed through")
+			}
+			if got := bw.body.Len(); got != tt.want {
+				t.Fatalf("captured %d bytes, want %d", got, tt.want)
+			}
+		})
+	}
+}
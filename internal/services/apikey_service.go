@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sdrvirtual/codewoot/internal/auth"
+	"github.com/sdrvirtual/codewoot/internal/config"
+	"github.com/sdrvirtual/codewoot/internal/db"
+)
+
+var (
+	ErrInvalidAPIKey  = errors.New("invalid api key")
+	ErrAPIKeyNotFound = errors.New("api key not found")
+)
+
+type APIKeyService struct {
+	cfg *config.Config
+	db  *db.Queries
+}
+
+func NewAPIKeyService(cfg *config.Config, p *pgxpool.Pool) *APIKeyService {
+	return &APIKeyService{cfg: cfg, db: db.New(p)}
+}
+
+// Authenticate resolves the principal behind an Api-Key header. The global
+// API_KEY is an admin, anything else must be a non revoked tenant key.
+func (s *APIKeyService) Authenticate(ctx context.Context, key string) (*auth.Principal, error) {
+	if s.cfg.Authorization.Key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(s.cfg.Authorization.Key)) == 1 {
+		return &auth.Principal{Name: "admin", Admin: true}, nil
+	}
+
+	prefix, secret, err := auth.ParseKey(key)
+	if err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+	apiKey, err := s.db.GetApiKeyByPrefix(ctx, prefix)
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+	if apiKey.RevokedAt.Valid || !auth.VerifySecret(secret, apiKey.KeyHash) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	principal := &auth.Principal{
+		Name:       apiKey.Name,
+		AccountIDs: apiKey.ChatwootAccountIds,
+	}
+	for _, p := range apiKey.Permissions {
+		principal.Permissions = append(principal.Permissions, auth.Permission(p))
+	}
+	for _, id := range apiKey.SessionIds {
+		principal.SessionIDs = append(principal.SessionIDs, id.String())
+	}
+	return principal, nil
+}
+
+// Issue creates a tenant key. The plaintext key is only returned here,
+// just its hash is stored.
+func (s *APIKeyService) Issue(ctx context.Context, name string, permissions []string, sessionIDs []string, accountIDs []int32) (*db.ApiKey, string, error) {
+	if name == "" {
+		return nil, "", fmt.Errorf("name is required")
+	}
+	if len(permissions) == 0 {
+		return nil, "", fmt.Errorf("at least one permission is required")
+	}
+	for _, p := range permissions {
+		if !auth.ValidPermission(p) {
+			return nil, "", fmt.Errorf("unknown permission %q", p)
+		}
+	}
+	sessions := make([]pgtype.UUID, 0, len(sessionIDs))
+	for _, id := range sessionIDs {
+		var u pgtype.UUID
+		if err := u.Scan(id); err != nil {
+			return nil, "", fmt.Errorf("session %q is not a uuid", id)
+		}
+		sessions = append(sessions, u)
+	}
+	if accountIDs == nil {
+		accountIDs = []int32{}
+	}
+
+	key, prefix, hash, err := auth.GenerateKey()
+	if err != nil {
+		return nil, "", err
+	}
+	apiKey, err := s.db.CreateApiKey(ctx, db.CreateApiKeyParams{
+		Name:               name,
+		Prefix:             prefix,
+		KeyHash:            hash,
+		Permissions:        permissions,
+		SessionIds:         sessions,
+		ChatwootAccountIds: accountIDs,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return &apiKey, key, nil
+}
+
+func (s *APIKeyService) List(ctx context.Context) ([]db.ApiKey, error) {
+	return s.db.ListApiKeys(ctx)
+}
+
+func (s *APIKeyService) Revoke(ctx context.Context, id int32) error {
+	n, err := s.db.RevokeApiKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
@@ -1,62 +1,326 @@
 package services
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"maps"
+	"sync/atomic"
+	"time"
 
 	"github.com/sdrvirtual/codewoot/internal/chatwoot"
 	"github.com/sdrvirtual/codewoot/internal/config"
 	"github.com/sdrvirtual/codewoot/internal/db"
 	"github.com/sdrvirtual/codewoot/internal/domain"
 	"github.com/sdrvirtual/codewoot/internal/dto"
+	"github.com/sdrvirtual/codewoot/internal/utils"
 )
 
 type ChatwootService struct {
-	cfg     *config.Config
-	client  *chatwoot.Client
-	inboxID int
+	cfg          *config.Config
+	client       *chatwoot.Client
+	db           *db.Store
+	session      db.CodechatSession
+	inboxID      int
+	tokenInvalid atomic.Bool
+	// Whether the last inbox webhook update failed, see ReconcileWebhook
+	webhookFailing atomic.Bool
+	// Source of contact avatars, nil disables the profile sync
+	profiles profilePictures
+}
+
+type profilePictures interface {
+	ProfilePictureURL(ctx context.Context, jid string) (string, error)
 }
 
 type ConversationID int
 
-func NewChatwootService(cfg *config.Config, session db.CodechatSession) *ChatwootService {
+func NewChatwootService(cfg *config.Config, q *db.Store, session db.CodechatSession, opts ...chatwoot.Option) (*ChatwootService, error) {
 	token := session.ChatwootToken
 	accountID := int(session.ChatwootAccountID)
 	inboxID := int(session.ChatwootInboxID)
-	client, err := chatwoot.New(cfg.Chatwoot.URL, token, accountID)
+	if token == "" {
+		return nil, fmt.Errorf("chatwoot token is required")
+	}
+	if accountID <= 0 {
+		return nil, fmt.Errorf("chatwoot account_id is required")
+	}
+	if inboxID <= 0 {
+		return nil, fmt.Errorf("chatwoot inbox_id is required")
+	}
+	client, err := chatwoot.New(cfg.Chatwoot.URL, token, accountID, opts...)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("chatwoot client: %w", err)
 	}
 
-	return &ChatwootService{cfg, client, inboxID}
+	c := &ChatwootService{
+		cfg:     cfg,
+		client:  client,
+		db:      q,
+		session: session,
+		inboxID: inboxID,
+	}
+	c.tokenInvalid.Store(session.ChatwootTokenInvalid)
+	c.webhookFailing.Store(session.WebhookError != "")
+	return c, nil
 }
 
-func (c *ChatwootService) SetupContact(ctx context.Context, contact *domain.ContactInfo) (*dto.CWContact, error) {
-	ctt, err := c.client.GetContactByPhone(ctx, contact.Phone)
+// opContext bounds a single call to the Chatwoot API. A relay makes
+// several of them, each gets the full Relay.Timeout.
+func (c *ChatwootService) opContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, c.cfg.Relay.Timeout)
+}
+
+// handleAPIError flags the session when Chatwoot rejects its token, so
+// operators can see it needs a new one.
+func (c *ChatwootService) handleAPIError(ctx context.Context, err error) error {
+	if !chatwoot.IsUnauthorized(err) {
+		return err
+	}
+	if c.tokenInvalid.CompareAndSwap(false, true) {
+		if dbErr := c.db.SetChatwootTokenInvalid(ctx, db.SetChatwootTokenInvalidParams{
+			SessionID:            c.session.SessionID,
+			ChatwootTokenInvalid: true,
+		}); dbErr != nil {
+			log.Printf("session %s: error flagging chatwoot token as invalid: %v", c.session.SessionID.String(), dbErr)
+			c.tokenInvalid.Store(false)
+		}
+	}
+	return fmt.Errorf("chatwoot token for session %s was rejected: %w", c.session.SessionID.String(), err)
+}
+
+// ReconcileWebhook points the webhook of the session's inbox back at the
+// relay when it differs, returning why it was fixed or "" when it wasn't.
+// Only API channel inboxes have one. Updating an inbox takes an admin token,
+// so a rejected update is kept on the session as its webhook error instead
+// of flagging the token, only a rejected read does that.
+func (c *ChatwootService) ReconcileWebhook(ctx context.Context) (string, error) {
+	want, err := ChatwootWebhookURL(c.cfg, c.session)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	opCtx, cancel := c.opContext(ctx)
+	inbox, err := c.client.GetInbox(opCtx, c.inboxID)
+	cancel()
+	if err != nil {
+		return "", c.handleAPIError(ctx, err)
+	}
+	if inbox.ChannelType != "Channel::Api" || inbox.WebhookURL == want {
+		c.setWebhookError(ctx, "")
+		return "", nil
+	}
+	drift := "inbox webhook url changed"
+	if inbox.WebhookURL == "" {
+		drift = "no inbox webhook set"
+	}
+	opCtx, cancel = c.opContext(ctx)
+	defer cancel()
+	if err := c.client.UpdateInboxWebhook(opCtx, c.inboxID, want); err != nil {
+		err = fmt.Errorf("webhook could not be updated (%s): %w", drift, err)
+		c.setWebhookError(ctx, err.Error())
+		return "", err
+	}
+	c.setWebhookError(ctx, "")
+	return drift, nil
+}
+
+// setWebhookError records why the inbox webhook couldn't be updated, ""
+// clears it. Only changes are written.
+func (c *ChatwootService) setWebhookError(ctx context.Context, reason string) {
+	failing := reason != ""
+	if !failing && !c.webhookFailing.Load() {
+		return
+	}
+	if err := c.db.SetWebhookError(ctx, db.SetWebhookErrorParams{
+		SessionID:    c.session.SessionID,
+		WebhookError: reason,
+	}); err != nil {
+		log.Printf("session %s: error recording webhook error: %v", c.session.SessionID.String(), err)
+		return
+	}
+	c.webhookFailing.Store(failing)
+}
+
+// SetupContact finds the Chatwoot contact by its WhatsApp JID, then by its
+// exact phone number, and creates it when neither matches. Contacts found
+// by phone get the JID as identifier so the next lookup doesn't search.
+// Contacts behind a LID have no phone and are only found by their JID.
+func (c *ChatwootService) SetupContact(ctx context.Context, contact *domain.ContactInfo) (*dto.CWContact, error) {
+	if contact.JID != "" {
+		opCtx, cancel := c.opContext(ctx)
+		ctt, err := c.client.GetContactByIdentifier(opCtx, contact.JID)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if ctt != nil {
+			return ctt, nil
+		}
 	}
-	if ctt == nil {
-		ctt, err = c.client.CreateContact(ctx, chatwoot.CreateContactParams{
-			InboxID:     c.inboxID,
-			Name:        contact.Name,
-			PhoneNumber: contact.Phone},
-		)
+	var ctt *dto.CWContact
+	if contact.Phone != "" {
+		opCtx, cancel := c.opContext(ctx)
+		found, err := c.client.GetContactByPhone(opCtx, contact.Phone)
+		cancel()
 		if err != nil {
 			return nil, err
 		}
+		ctt = found
+	}
+	if ctt != nil {
+		if contact.JID != "" && (ctt.Identifier == nil || *ctt.Identifier == "") {
+			opCtx, cancel := c.opContext(ctx)
+			_, err := c.client.UpdateContact(opCtx, ctt.ID, chatwoot.UpdateContactParams{Identifier: &contact.JID})
+			cancel()
+			if err != nil {
+				log.Printf("contact %d: error setting identifier %s: %v", ctt.ID, contact.JID, err)
+			}
+		}
+		return ctt, nil
+	}
+	params := chatwoot.CreateContactParams{
+		InboxID:     c.inboxID,
+		Name:        contact.Name,
+		PhoneNumber: contact.Phone,
+		Identifier:  contact.JID,
+	}
+	if contact.Metadata != nil {
+		params.AdditionalAttributes = contact.Metadata.Attributes()
+	}
+	opCtx, cancel := c.opContext(ctx)
+	defer cancel()
+	return c.client.CreateContact(opCtx, params)
+}
+
+const (
+	// Additional attributes keeping track of the profile sync
+	attrWhatsAppName     = "whatsapp_name"
+	attrWhatsAppSyncedAt = "whatsapp_synced_at"
+)
+
+// refreshContact brings the WhatsApp metadata of the contact up to date,
+// and its name and avatar right after it's created and then once per
+// ContactSyncInterval. Failures are only logged, the message is delivered
+// anyway.
+func (c *ChatwootService) refreshContact(ctx context.Context, ctt *dto.CWContact, contact *domain.ContactInfo) {
+	var params chatwoot.UpdateContactParams
+	var syncProfile bool
+	if c.profiles != nil && contact.JID != "" {
+		params, syncProfile = contactProfileUpdate(*ctt, contact.Name, time.Now(), c.cfg.Sessions.ContactSyncInterval)
+	}
+	var changed map[string]any
+	if contact.Metadata != nil {
+		changed = changedAttributes(ctt.AdditionalAttributes, contact.Metadata.Attributes())
+	}
+	if !syncProfile && len(changed) == 0 {
+		return
+	}
+
+	if len(changed) > 0 {
+		if params.AdditionalAttributes == nil {
+			params.AdditionalAttributes = map[string]any{}
+		}
+		maps.Copy(params.AdditionalAttributes, changed)
+	}
+	if syncProfile {
+		opCtx, cancel := c.opContext(ctx)
+		avatar, err := c.profiles.ProfilePictureURL(opCtx, contact.JID)
+		cancel()
+		if err != nil {
+			log.Printf("contact %d: error fetching profile picture: %v", ctt.ID, err)
+		} else if avatar != "" {
+			params.AvatarURL = &avatar
+		}
+	}
+	opCtx, cancel := c.opContext(ctx)
+	defer cancel()
+	if _, err := c.client.UpdateContact(opCtx, ctt.ID, params); err != nil {
+		log.Printf("contact %d: error updating contact: %v", ctt.ID, c.handleAPIError(ctx, err))
 	}
-	return ctt, nil
 }
 
-func (c *ChatwootService) setupInbox(ctx context.Context, contact *dto.CWContact) (*dto.CWContactInbox, error) {
-	for _, ci := range contact.ContactInboxes {
+// changedAttributes returns the attributes of want missing or different
+// in current.
+func changedAttributes(current, want map[string]any) map[string]any {
+	changed := map[string]any{}
+	for k, v := range want {
+		if cur, ok := current[k]; !ok || fmt.Sprint(cur) != fmt.Sprint(v) {
+			changed[k] = v
+		}
+	}
+	return changed
+}
+
+// refreshConversation writes the WhatsApp metadata into the custom
+// attributes of an existing conversation when it changed.
+func (c *ChatwootService) refreshConversation(ctx context.Context, conv *dto.CWConversation, contact *domain.ContactInfo) {
+	if contact.Metadata == nil {
+		return
+	}
+	changed := changedAttributes(conv.CustomAttributes, contact.Metadata.Attributes())
+	if len(changed) == 0 {
+		return
+	}
+	// The endpoint replaces them all
+	attrs := maps.Clone(conv.CustomAttributes)
+	if attrs == nil {
+		attrs = map[string]any{}
+	}
+	maps.Copy(attrs, changed)
+	opCtx, cancel := c.opContext(ctx)
+	defer cancel()
+	if err := c.client.SetConversationCustomAttributes(opCtx, conv.ID, attrs); err != nil {
+		log.Printf("conversation %d: error setting custom attributes: %v", conv.ID, c.handleAPIError(ctx, err))
+	}
+}
+
+// contactProfileUpdate returns the update bringing the contact in line with
+// its WhatsApp push name, and whether a sync is due. The name is only
+// replaced while it's still the one set by the last sync (or the phone
+// number), so names edited by agents are kept.
+func contactProfileUpdate(ctt dto.CWContact, pushName string, now time.Time, interval time.Duration) (chatwoot.UpdateContactParams, bool) {
+	var params chatwoot.UpdateContactParams
+	if interval <= 0 {
+		return params, false
+	}
+	syncedAt, _ := ctt.AdditionalAttributes[attrWhatsAppSyncedAt].(float64)
+	if syncedAt > 0 && now.Sub(time.Unix(int64(syncedAt), 0)) < interval {
+		return params, false
+	}
+
+	params.AdditionalAttributes = map[string]any{attrWhatsAppSyncedAt: now.Unix()}
+	// Mirrored messages carry our own push name, they use the phone instead
+	if pushName == "" || utils.SamePhone(pushName, ctt.PhoneNumber) {
+		return params, true
+	}
+	params.AdditionalAttributes[attrWhatsAppName] = pushName
+
+	syncedName, _ := ctt.AdditionalAttributes[attrWhatsAppName].(string)
+	untouched := ctt.Name == "" || ctt.Name == syncedName || utils.SamePhone(ctt.Name, ctt.PhoneNumber)
+	if untouched && ctt.Name != pushName {
+		params.Name = &pushName
+	}
+	return params, true
+}
+
+// setupInbox returns the contact inbox of the contact, creating it with the
+// JID as source_id so replies can be routed by it.
+func (c *ChatwootService) setupInbox(ctx context.Context, ctt *dto.CWContact, jid string) (*dto.CWContactInbox, error) {
+	for _, ci := range ctt.ContactInboxes {
 		if ci.Inbox.ID == c.inboxID {
 			return &ci, nil
 		}
 	}
-	cttInbox, err := c.client.CreateContactInbox(ctx, contact.ID, chatwoot.CreateContactInboxParams{InboxID: c.inboxID})
+	params := chatwoot.CreateContactInboxParams{InboxID: c.inboxID}
+	if jid != "" {
+		params.SourceID = &jid
+	}
+	opCtx, cancel := c.opContext(ctx)
+	defer cancel()
+	cttInbox, err := c.client.CreateContactInbox(opCtx, ctt.ID, params)
 	if err != nil {
 		return nil, err
 	}
@@ -71,36 +335,205 @@ func (c *ChatwootService) setupConversation(ctx context.Context, contact *domain
 	if ctt == nil || ctt.ID < 1 {
 		return -1, fmt.Errorf("couldn't find or create account")
 	}
+	c.refreshContact(ctx, ctt, contact)
 
-	cttConv, err := c.client.GetContactConversations(ctx, ctt.ID)
+	var statuses []string
+	if c.session.Settings.ReopenPolicy == domain.ReopenPolicyNew {
+		// Resolved conversations are never reused
+		statuses = activeConversationStatuses
+	}
+	opCtx, cancel := c.opContext(ctx)
+	cttConv, err := c.client.GetContactConversations(opCtx, ctt.ID, statuses...)
+	cancel()
 	if err != nil {
 		return -1, err
 	}
-	// Try to find an open conversation
-	for _, conv := range cttConv {
-		if conv.InboxID == c.inboxID {
-			return ConversationID(conv.ID), nil
+	conv, reopen := pickConversation(cttConv, c.inboxID, c.session.Settings, time.Now())
+	if conv == nil {
+		// Not found, on another inbox, or left resolved by the policy
+		return c.createConversation(ctx, ctt, contact)
+	}
+	if reopen {
+		opCtx, cancel := c.opContext(ctx)
+		err := c.client.ToggleConversationStatus(opCtx, conv.ID, "open")
+		cancel()
+		if err != nil {
+			return -1, err
 		}
 	}
+	c.refreshConversation(ctx, conv, contact)
+	return ConversationID(conv.ID), nil
+}
 
-	// Conversation not found, or on another inbox
-	cttInbox, err := c.setupInbox(ctx, ctt)
-	if cttInbox.SourceID == "" {
-		return -1, fmt.Errorf("source_id unavaliable")
+var activeConversationStatuses = []string{"open", "pending", "snoozed"}
+
+// pickConversation chooses the conversation of the inbox a new message
+// goes to. A conversation that isn't resolved is always reused, otherwise
+// the reopen policy decides whether the last resolved one is reopened. It
+// returns nil when a new conversation must be started.
+func pickConversation(convs []dto.CWConversation, inboxID int, settings domain.SessionSettings, now time.Time) (*dto.CWConversation, bool) {
+	var last *dto.CWConversation
+	for i := range convs {
+		conv := &convs[i]
+		if conv.InboxID != inboxID {
+			continue
+		}
+		if conv.Status != "resolved" {
+			return conv, false
+		}
+		if last == nil || conv.LastActivityAt > last.LastActivityAt {
+			last = conv
+		}
+	}
+	if last == nil {
+		return nil, false
+	}
+	switch settings.ReopenPolicy {
+	case domain.ReopenPolicyNew:
+		return nil, false
+	case domain.ReopenPolicyInactivity:
+		lastActivity := time.Unix(int64(last.LastActivityAt), 0)
+		if now.Sub(lastActivity) > time.Duration(settings.InactivityHours)*time.Hour {
+			return nil, false
+		}
 	}
+	return last, true
+}
+
+func (c *ChatwootService) createConversation(ctx context.Context, ctt *dto.CWContact, contact *domain.ContactInfo) (ConversationID, error) {
+	cttInbox, err := c.setupInbox(ctx, ctt, contact.JID)
 	if err != nil {
 		return -1, err
 	}
-	convID, err := c.client.CreateConversation(ctx, cttInbox.SourceID, cttInbox.Inbox.ID)
+	if cttInbox.SourceID == "" {
+		return -1, fmt.Errorf("source_id unavaliable")
+	}
+	var attrs map[string]any
+	if contact.Metadata != nil {
+		attrs = contact.Metadata.Attributes()
+	}
+	opCtx, cancel := c.opContext(ctx)
+	defer cancel()
+	convID, err := c.client.CreateConversation(opCtx, cttInbox.SourceID, cttInbox.Inbox.ID, attrs)
 	return ConversationID(convID), err
 }
 
-func (c *ChatwootService) SendMessage(ctx context.Context, contact domain.ContactInfo, message chatwoot.ChatwootClientMessage) error {
+// SendMessage posts message in the conversation of the contact, returning
+// the conversation it ended up in.
+func (c *ChatwootService) SendMessage(ctx context.Context, contact domain.ContactInfo, message chatwoot.ChatwootClientMessage) (ConversationID, error) {
 	id, err := c.setupConversation(ctx, &contact)
+	if err != nil {
+		return id, c.handleAPIError(ctx, err)
+	}
+	// Keep the attachment around so it can be sent again on retry
+	var attachment []byte
+	if message.Attachment != nil {
+		attachment, err = io.ReadAll(message.Attachment.File)
+		if err != nil {
+			return id, err
+		}
+		message.Attachment.File = bytes.NewReader(attachment)
+	}
 	message.ConversationID = int(id)
+	_, err = c.createMessage(ctx, message)
+	if chatwoot.IsNotFound(err) {
+		// The conversation was deleted in Chatwoot after we looked it up,
+		// start a new one and try again.
+		log.Printf("conversation %d not found, creating a new one", id)
+		id, err = c.recreateConversation(ctx, &contact)
+		if err != nil {
+			return id, c.handleAPIError(ctx, err)
+		}
+		message.ConversationID = int(id)
+		if message.Attachment != nil {
+			message.Attachment.File = bytes.NewReader(attachment)
+		}
+		_, err = c.createMessage(ctx, message)
+	}
+	return id, c.handleAPIError(ctx, err)
+}
+
+func (c *ChatwootService) createMessage(ctx context.Context, message chatwoot.ChatwootClientMessage) (*dto.CWMessage, error) {
+	opCtx, cancel := c.opContext(ctx)
+	defer cancel()
+	return c.client.CreateMessage(opCtx, message)
+}
+
+func (c *ChatwootService) recreateConversation(ctx context.Context, contact *domain.ContactInfo) (ConversationID, error) {
+	ctt, err := c.SetupContact(ctx, contact)
 	if err != nil {
-		return err
+		return -1, err
+	}
+	return c.createConversation(ctx, ctt, contact)
+}
+
+// Reply posts text as an agent reply in the conversation. Chatwoot sends
+// it to the relay webhook like any other reply, so it reaches WhatsApp.
+func (c *ChatwootService) Reply(ctx context.Context, conversationID ConversationID, text string) error {
+	reply := chatwoot.NewChatwootClientMessage()
+	reply.ConversationID = int(conversationID)
+	reply.MessageType = dto.Outgoing
+	reply.Text = text
+	_, err := c.createMessage(ctx, reply)
+	return c.handleAPIError(ctx, err)
+}
+
+// AddPrivateNote posts text in the conversation, visible only to agents.
+func (c *ChatwootService) AddPrivateNote(ctx context.Context, conversationID int, text string) error {
+	note := chatwoot.NewChatwootClientMessage()
+	note.ConversationID = conversationID
+	note.MessageType = dto.Outgoing
+	note.Private = true
+	note.Text = text
+	_, err := c.createMessage(ctx, note)
+	return c.handleAPIError(ctx, err)
+}
+
+// MarkMessageFailed flags an agent message as not delivered and explains
+// why in a private note, so it doesn't look sent.
+func (c *ChatwootService) MarkMessageFailed(ctx context.Context, conversationID, messageID int, reason string) error {
+	opCtx, cancel := c.opContext(ctx)
+	statusErr := c.handleAPIError(ctx, c.client.UpdateMessageStatus(opCtx, conversationID, messageID, "failed", reason))
+	cancel()
+	noteErr := c.AddPrivateNote(ctx, conversationID, "⚠️ The message above wasn't delivered to WhatsApp: "+reason)
+	return errors.Join(statusErr, noteErr)
+}
+
+// Caps the private notes posted for one connection change
+const maxNotifiedConversations = 250
+
+// NotifyOpenConversations posts text as a private note in the open
+// conversations of the inbox, where the agents replying will see it.
+func (c *ChatwootService) NotifyOpenConversations(ctx context.Context, text string) error {
+	notified := 0
+	for page := 1; notified < maxNotifiedConversations; page++ {
+		opCtx, cancel := c.opContext(ctx)
+		convs, err := c.client.ListConversations(opCtx, chatwoot.ListConversationsParams{
+			InboxID: c.inboxID,
+			Status:  "open",
+			Page:    page,
+		})
+		cancel()
+		if err != nil {
+			return c.handleAPIError(ctx, err)
+		}
+		if len(convs) == 0 {
+			return nil
+		}
+		for _, conv := range convs {
+			if notified == maxNotifiedConversations {
+				break
+			}
+			// Filtered by Chatwoot already, but the note must not leak
+			// into other inboxes
+			if conv.InboxID != c.inboxID {
+				continue
+			}
+			if err := c.AddPrivateNote(ctx, conv.ID, text); err != nil {
+				return err
+			}
+			notified++
+		}
 	}
-	_, err = c.client.CreateMessage(ctx, message)
-	return err
+	return nil
 }
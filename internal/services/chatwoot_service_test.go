@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sdrvirtual/codewoot/internal/config"
+	"github.com/sdrvirtual/codewoot/internal/db"
+	"github.com/sdrvirtual/codewoot/internal/domain"
+	"github.com/sdrvirtual/codewoot/internal/dto"
+)
+
+func TestPickConversation(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	hoursAgo := func(h int) float64 { return float64(now.Add(-time.Duration(h) * time.Hour).Unix()) }
+
+	resolved := []dto.CWConversation{
+		{ID: 1, InboxID: 5, Status: "resolved", LastActivityAt: hoursAgo(48)},
+		{ID: 2, InboxID: 5, Status: "resolved", LastActivityAt: hoursAgo(30)},
+		{ID: 3, InboxID: 6, Status: "open", LastActivityAt: hoursAgo(1)},
+	}
+	withOpen := append([]dto.CWConversation{{ID: 4, InboxID: 5, Status: "pending"}}, resolved...)
+
+	tests := []struct {
+		name       string
+		convs      []dto.CWConversation
+		settings   domain.SessionSettings
+		wantID     int
+		wantReopen bool
+	}{
+		{"none", nil, domain.SessionSettings{}, 0, false},
+		{"other inbox only", resolved[2:], domain.SessionSettings{}, 0, false},
+		{"active reused", withOpen, domain.SessionSettings{ReopenPolicy: domain.ReopenPolicyNew}, 4, false},
+		{"reopen last resolved", resolved, domain.SessionSettings{}, 2, true},
+		{"new after resolve", resolved, domain.SessionSettings{ReopenPolicy: domain.ReopenPolicyNew}, 0, false},
+		{"recently active", resolved, domain.SessionSettings{ReopenPolicy: domain.ReopenPolicyInactivity, InactivityHours: 36}, 2, true},
+		{"inactive", resolved, domain.SessionSettings{ReopenPolicy: domain.ReopenPolicyInactivity, InactivityHours: 24}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv, reopen := pickConversation(tt.convs, 5, tt.settings, now)
+			gotID := 0
+			if conv != nil {
+				gotID = conv.ID
+			}
+			if gotID != tt.wantID || reopen != tt.wantReopen {
+				t.Fatalf("pickConversation() = %d, %v, want %d, %v", gotID, reopen, tt.wantID, tt.wantReopen)
+			}
+		})
+	}
+}
+
+func TestContactProfileUpdate(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	day := 24 * time.Hour
+	syncedAt := func(ago time.Duration) float64 { return float64(now.Add(-ago).Unix()) }
+
+	tests := []struct {
+		name     string
+		ctt      dto.CWContact
+		pushName string
+		interval time.Duration
+		wantDue  bool
+		wantName string
+	}{
+		{"disabled", dto.CWContact{Name: "Ana"}, "Ana", 0, false, ""},
+		{"recently synced", dto.CWContact{Name: "Ana", AdditionalAttributes: map[string]any{
+			attrWhatsAppSyncedAt: syncedAt(time.Hour),
+		}}, "Ana Maria", day, false, ""},
+		{"new contact keeps its name", dto.CWContact{Name: "Ana"}, "Ana", day, true, ""},
+		{"named after its phone", dto.CWContact{Name: "+55 11 98877-6655", PhoneNumber: "+5511988776655"}, "Ana", day, true, "Ana"},
+		{"push name changed", dto.CWContact{Name: "Ana", AdditionalAttributes: map[string]any{
+			attrWhatsAppName:     "Ana",
+			attrWhatsAppSyncedAt: syncedAt(2 * day),
+		}}, "Ana Maria", day, true, "Ana Maria"},
+		{"edited by an agent", dto.CWContact{Name: "Ana (VIP)", AdditionalAttributes: map[string]any{
+			attrWhatsAppName:     "Ana",
+			attrWhatsAppSyncedAt: syncedAt(2 * day),
+		}}, "Ana Maria", day, true, ""},
+		{"mirrored message", dto.CWContact{Name: "", PhoneNumber: "+5511988776655"}, "+5511988776655", day, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, due := contactProfileUpdate(tt.ctt, tt.pushName, now, tt.interval)
+			if due != tt.wantDue {
+				t.Fatalf("due = %v, want %v", due, tt.wantDue)
+			}
+			var gotName string
+			if params.Name != nil {
+				gotName = *params.Name
+			}
+			if gotName != tt.wantName {
+				t.Fatalf("name = %q, want %q", gotName, tt.wantName)
+			}
+			if due && params.AdditionalAttributes[attrWhatsAppSyncedAt] != now.Unix() {
+				t.Fatalf("synced at not recorded: %+v", params.AdditionalAttributes)
+			}
+		})
+	}
+}
+
+func TestChatwootService_ReconcileWebhook(t *testing.T) {
+	session := testSession(t, "b1f2f0f4-7930-4e90-9b6f-8c0f3c9b6c12")
+	tests := []struct {
+		name      string
+		inbox     string
+		wantDrift string
+	}{
+		{"legacy url", `{"id":7,"channel_type":"Channel::Api","webhook_url":"https://bridge.example.com/chatwoot/webhook/b1f2f0f4-7930-4e90-9b6f-8c0f3c9b6c12"}`, "inbox webhook url changed"},
+		{"none", `{"id":7,"channel_type":"Channel::Api","webhook_url":""}`, "no inbox webhook set"},
+		{"match", `{"id":7,"channel_type":"Channel::Api","webhook_url":"https://bridge.example.com/chatwoot/webhook/b1f2f0f4-7930-4e90-9b6f-8c0f3c9b6c12?secret=s3cr3t"}`, ""},
+		{"not an api inbox", `{"id":7,"channel_type":"Channel::Whatsapp"}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patched string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch r.Method {
+				case http.MethodGet:
+					_, _ = w.Write([]byte(tt.inbox))
+				case http.MethodPatch:
+					body, _ := io.ReadAll(r.Body)
+					patched = string(body)
+					_, _ = w.Write([]byte(`{"id":7}`))
+				}
+			}))
+			t.Cleanup(srv.Close)
+
+			cfg := &config.Config{}
+			cfg.Server.URL = "https://bridge.example.com"
+			cfg.Chatwoot.URL = srv.URL
+			cfg.Relay.Timeout = time.Second
+			c, err := NewChatwootService(cfg, nil, session)
+			if err != nil {
+				t.Fatalf("NewChatwootService() error: %v", err)
+			}
+
+			drift, err := c.ReconcileWebhook(context.Background())
+			if err != nil {
+				t.Fatalf("ReconcileWebhook() error: %v", err)
+			}
+			if drift != tt.wantDrift {
+				t.Fatalf("ReconcileWebhook() = %q, want %q", drift, tt.wantDrift)
+			}
+			wantPatch := ""
+			if tt.wantDrift != "" {
+				wantPatch = `{"channel":{"webhook_url":"https://bridge.example.com/chatwoot/webhook/b1f2f0f4-7930-4e90-9b6f-8c0f3c9b6c12?secret=s3cr3t"}}`
+			}
+			if strings.TrimSpace(patched) != wantPatch {
+				t.Fatalf("patched %q, want %q", patched, wantPatch)
+			}
+		})
+	}
+}
+
+func TestChatwootService_ReconcileWebhookRejected(t *testing.T) {
+	session := testSession(t, "b1f2f0f4-7930-4e90-9b6f-8c0f3c9b6c12")
+	const inbox = "/api/v1/accounts/1/inboxes/7"
+	tests := []struct {
+		name             string
+		getStatus        int
+		wantTokenInvalid bool
+		wantWebhookError bool
+	}{
+		{"update rejected", http.StatusOK, false, true},
+		{"read rejected", http.StatusUnauthorized, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cw := newFakeAPI()
+			cw.reply("GET "+inbox, tt.getStatus, `{"id":7,"channel_type":"Channel::Api","webhook_url":""}`)
+			cw.reply("PATCH "+inbox, http.StatusUnauthorized, `{"error":"You are not authorized to do this action"}`)
+			srv := httptest.NewServer(cw)
+			t.Cleanup(srv.Close)
+
+			var tokenInvalid, webhookError []any
+			fdb := newFakeDB()
+			fdb.handle("SetChatwootTokenInvalid", func(args []any) ([][]any, error) {
+				tokenInvalid = args
+				return nil, nil
+			})
+			fdb.handle("SetWebhookError", func(args []any) ([][]any, error) {
+				webhookError = args
+				return nil, nil
+			})
+
+			cfg := &config.Config{}
+			cfg.Server.URL = "https://bridge.example.com"
+			cfg.Chatwoot.URL = srv.URL
+			cfg.Relay.Timeout = time.Second
+			c, err := NewChatwootService(cfg, db.NewStore(fdb, nil), session)
+			if err != nil {
+				t.Fatalf("NewChatwootService() error: %v", err)
+			}
+
+			if _, err := c.ReconcileWebhook(context.Background()); err == nil {
+				t.Fatal("ReconcileWebhook() error = nil")
+			}
+			if got := tokenInvalid != nil; got != tt.wantTokenInvalid {
+				t.Fatalf("token flagged = %v, want %v", got, tt.wantTokenInvalid)
+			}
+			if got := webhookError != nil; got != tt.wantWebhookError {
+				t.Fatalf("webhook error recorded = %v, want %v", got, tt.wantWebhookError)
+			}
+			if tt.wantWebhookError && !strings.Contains(webhookError[1].(string), "webhook could not be updated") {
+				t.Fatalf("webhook error = %q", webhookError[1])
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package services
 
 import (
+	"bytes"
 	"context"
-	"log"
+	"fmt"
+	"io"
 	"strings"
 
 	"github.com/sdrvirtual/codewoot/internal/audio"
@@ -18,18 +20,62 @@ type CodechatService struct {
 	client *codechat.Client
 }
 
-func NewCodechatService(cfg *config.Config, session db.CodechatSession) *CodechatService {
+func NewCodechatService(cfg *config.Config, session db.CodechatSession, opts ...codechat.Option) (*CodechatService, error) {
 	instance := session.CodechatInstance
 	instanceToken := session.CodechatInstcanceToken
-	codechatClient, err := codechat.New(cfg.Codechat.URL, cfg.Codechat.GlobalToken, codechat.WithInstanceToken(instanceToken, instance))
-
+	if instance == "" {
+		return nil, fmt.Errorf("codechat instance is required")
+	}
+	if instanceToken == "" {
+		return nil, fmt.Errorf("codechat instance token is required")
+	}
+	opts = append([]codechat.Option{codechat.WithInstanceToken(instanceToken, instance)}, opts...)
+	codechatClient, err := codechat.New(cfg.Codechat.URL, cfg.Codechat.GlobalToken, opts...)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("codechat client: %w", err)
 	}
 	return &CodechatService{
 		cfg:    cfg,
 		client: codechatClient,
+	}, nil
+}
+
+// ReconcileWebhook sets the webhook of the instance again when it differs
+// from the expected one, returning why it was fixed or "" when it wasn't.
+func (c *CodechatService) ReconcileWebhook(ctx context.Context, session db.CodechatSession) (string, error) {
+	u, err := CodechatWebhookURL(c.cfg, session)
+	if err != nil {
+		return "", err
+	}
+	instance, err := c.client.FetchInstance(ctx)
+	if err != nil {
+		return "", err
+	}
+	want := codechat.NewSetWebhookParams(u)
+	drift := webhookDrift(*want, instance.Webhook.SetWebhookParams)
+	if drift == "" {
+		return "", nil
 	}
+	if _, err := c.client.SetWebhook(ctx, want); err != nil {
+		return "", fmt.Errorf("fixing webhook (%s): %w", drift, err)
+	}
+	return drift, nil
+}
+
+// webhookDrift describes how the webhook set in Codechat differs from the
+// expected one, or returns "" when they match.
+func webhookDrift(want, got codechat.SetWebhookParams) string {
+	switch {
+	case got.URL == "":
+		return "no webhook set"
+	case got.URL != want.URL:
+		return "webhook url changed"
+	case got.Enabled != want.Enabled:
+		return "webhook disabled"
+	case got.Events != want.Events:
+		return "webhook events changed"
+	}
+	return ""
 }
 
 type CodechatClientMessage struct {
@@ -60,60 +106,85 @@ func (c *CodechatService) GetAudioContent(ctx context.Context, message *dto.Code
 	return data, nil
 }
 
-func (c *CodechatService) SendMessage(ctx context.Context, contact domain.ContactInfo, message CodechatClientMessage) error {
-	if message.MediaURL != nil {
-		params := codechat.SendMediaParams{
-			Number: contact.Phone,
+// ConnectionStatus returns the Codechat connection status of the instance,
+// e.g. ONLINE.
+func (c *CodechatService) ConnectionStatus(ctx context.Context) (string, error) {
+	resp, err := c.client.FetchInstance(ctx)
+	if err != nil {
+		return "", err
+	}
+	return resp.ConnectionStatus, nil
+}
+
+// ProfilePictureURL returns the WhatsApp profile picture of jid, "" when
+// it's hidden. A LID isn't a phone number Codechat can look up, those
+// contacts get no picture.
+func (c *CodechatService) ProfilePictureURL(ctx context.Context, jid string) (string, error) {
+	if strings.HasSuffix(jid, "@lid") {
+		return "", nil
+	}
+	return c.client.FetchProfilePictureURL(ctx, strings.Split(jid, "@")[0])
+}
+
+// GetImageContent downloads the image of message. It's read whole while
+// ctx is alive, the caller bounds the download and not the upload to
+// Chatwoot that follows.
+func (c *CodechatService) GetImageContent(ctx context.Context, message *dto.CodechatData) (*dto.FileData, error) {
+	data, err := c.client.GetMediaData(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	if rc, ok := data.File.(io.Closer); ok {
+		defer rc.Close()
+	}
+	image, err := io.ReadAll(data.File)
+	if err != nil {
+		return nil, err
+	}
+	data.File = bytes.NewReader(image)
+	return data, nil
+}
+
+// SendMessage sends message to the contact, returning the key ID WhatsApp
+// gave it, "" when there was nothing to send.
+func (c *CodechatService) SendMessage(ctx context.Context, contact domain.ContactInfo, message CodechatClientMessage) (string, error) {
+	var sent *codechat.SentMessage
+	var err error
+	switch {
+	case message.MediaURL != nil:
+		sent, err = c.client.SendMedia(ctx, codechat.SendMediaParams{
+			Number: contact.Destination(),
 			MediaMessage: codechat.CCMediaMessage{
 				Media:     *message.MediaURL,
 				Mediatype: "image",
 				Caption:   message.Text,
 			},
-		}
-		_, err := c.client.SendMedia(ctx, params)
-		if err != nil {
-			return err
-		}
-		return nil
-	}
-	if message.AudioURL != nil {
-		params := codechat.SendWhatsappAudioParams{
-			Number:       contact.Phone,
+		})
+	case message.AudioURL != nil:
+		sent, err = c.client.SendWhatsappAudio(ctx, codechat.SendWhatsappAudioParams{
+			Number:       contact.Destination(),
 			AudioMessage: codechat.CCAudioMessage{Audio: *message.AudioURL},
-		}
-		_, err := c.client.SendWhatsappAudio(ctx, params)
-		if err != nil {
-			return err
-		}
-		return nil
-	}
-	if message.FileURL != nil {
-		params := codechat.SendMediaParams{
-			Number: contact.Phone,
+		})
+	case message.FileURL != nil:
+		sent, err = c.client.SendMedia(ctx, codechat.SendMediaParams{
+			Number: contact.Destination(),
 			MediaMessage: codechat.CCMediaMessage{
 				Media:     *message.FileURL,
 				FileName:  *message.AttachmentName,
 				Mediatype: "document",
 				Caption:   message.Text,
 			},
-		}
-		_, err := c.client.SendMedia(ctx, params)
-		if err != nil {
-			return err
-		}
-		return nil
-	}
-
-	if message.Text != "" && message.MediaURL == nil {
-		params := codechat.SendTextParams{
-			Number:      contact.Phone,
+		})
+	case message.Text != "":
+		sent, err = c.client.SendText(ctx, codechat.SendTextParams{
+			Number:      contact.Destination(),
 			TextMessage: codechat.CCTextMessage{Text: message.Text},
-		}
-		_, err := c.client.SendText(ctx, params)
-		if err != nil {
-			return err
-		}
-		return nil
-	}
-	return nil
+		})
+	default:
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return sent.Key.ID, nil
 }
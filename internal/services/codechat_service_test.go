@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sdrvirtual/codewoot/internal/config"
+)
+
+func TestCodechatService_ProfilePictureURL(t *testing.T) {
+	const route = "POST /chat/fetchProfilePictureUrl/inst"
+	tests := []struct {
+		jid        string
+		want       string
+		wantNumber string
+	}{
+		{"5511988776655@s.whatsapp.net", "https://pps.whatsapp.net/v/t61/ana.jpg", `{"number":"5511988776655"}`},
+		{"123456789012345@lid", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.jid, func(t *testing.T) {
+			cc := newFakeAPI()
+			cc.reply(route, http.StatusOK, `{"profilePictureUrl":"https://pps.whatsapp.net/v/t61/ana.jpg"}`)
+			srv := httptest.NewServer(cc)
+			t.Cleanup(srv.Close)
+
+			cfg := &config.Config{}
+			cfg.Codechat.URL = srv.URL
+			c, err := NewCodechatService(cfg, testSession(t, "b1f2f0f4-7930-4e90-9b6f-8c0f3c9b6c12"))
+			if err != nil {
+				t.Fatalf("NewCodechatService() error: %v", err)
+			}
+			got, err := c.ProfilePictureURL(context.Background(), tt.jid)
+			if err != nil {
+				t.Fatalf("ProfilePictureURL() error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("ProfilePictureURL() = %q, want %q", got, tt.want)
+			}
+			reqs := cc.got(route)
+			if tt.wantNumber == "" {
+				if len(reqs) != 0 {
+					t.Fatalf("fetched picture of a LID: %v", reqs)
+				}
+				return
+			}
+			if len(reqs) != 1 || strings.TrimSpace(reqs[0].Body) != tt.wantNumber {
+				t.Fatalf("requests %v, want body %s", reqs, tt.wantNumber)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sdrvirtual/codewoot/internal/config"
+	"github.com/sdrvirtual/codewoot/internal/db"
+)
+
+// fakeDB answers the sqlc queries by their name, so services can be tested
+// without Postgres. Queries without a handler fail.
+type fakeDB struct {
+	mu sync.Mutex
+	// Rows returned by a :one or :many query, no rows means pgx.ErrNoRows
+	// for :one
+	queries map[string]func(args []any) ([][]any, error)
+	// Names of the :exec queries run, in order
+	execs []string
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{queries: make(map[string]func(args []any) ([][]any, error))}
+}
+
+func (f *fakeDB) handle(name string, fn func(args []any) ([][]any, error)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries[name] = fn
+}
+
+func (f *fakeDB) run(sql string, args []any) ([][]any, error) {
+	name := queryName(sql)
+	f.mu.Lock()
+	fn, ok := f.queries[name]
+	f.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("fakeDB: unexpected query %s", name)
+	}
+	return fn(args)
+}
+
+func (f *fakeDB) executed() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.execs...)
+}
+
+// queryName returns the name from the "-- name: X :kind" header sqlc puts
+// on every query.
+func queryName(sql string) string {
+	line, _, _ := strings.Cut(strings.TrimPrefix(sql, "-- name: "), "\n")
+	name, _, _ := strings.Cut(line, " ")
+	return name
+}
+
+// Exec records the query and runs its handler, if it has one.
+func (f *fakeDB) Exec(_ context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	name := queryName(sql)
+	f.mu.Lock()
+	f.execs = append(f.execs, name)
+	fn, ok := f.queries[name]
+	f.mu.Unlock()
+	if ok {
+		if _, err := fn(args); err != nil {
+			return pgconn.CommandTag{}, err
+		}
+	}
+	return pgconn.CommandTag{}, nil
+}
+
+func (f *fakeDB) Query(_ context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	rows, err := f.run(sql, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+func (f *fakeDB) QueryRow(_ context.Context, sql string, args ...interface{}) pgx.Row {
+	rows, err := f.run(sql, args)
+	if err != nil {
+		return fakeRow{err: err}
+	}
+	if len(rows) == 0 {
+		return fakeRow{err: pgx.ErrNoRows}
+	}
+	return fakeRow{values: rows[0]}
+}
+
+type fakeRow struct {
+	values []any
+	err    error
+}
+
+func (r fakeRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(dest) != len(r.values) {
+		return fmt.Errorf("fakeRow: scanning %d values into %d columns", len(r.values), len(dest))
+	}
+	for i, d := range dest {
+		reflect.ValueOf(d).Elem().Set(reflect.ValueOf(r.values[i]))
+	}
+	return nil
+}
+
+type fakeRows struct {
+	rows [][]any
+	i    int
+}
+
+func (r *fakeRows) Close()                                       {}
+func (r *fakeRows) Err() error                                   { return nil }
+func (r *fakeRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r *fakeRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *fakeRows) RawValues() [][]byte                          { return nil }
+func (r *fakeRows) Conn() *pgx.Conn                              { return nil }
+
+func (r *fakeRows) Next() bool {
+	if r.i == len(r.rows) {
+		return false
+	}
+	r.i++
+	return true
+}
+
+func (r *fakeRows) Scan(dest ...any) error {
+	return fakeRow{values: r.rows[r.i-1]}.Scan(dest...)
+}
+
+func (r *fakeRows) Values() ([]any, error) {
+	return r.rows[r.i-1], nil
+}
+
+// sessionRow returns the columns of a codechat_session row, in the order
+// the generated queries scan them.
+func sessionRow(s db.CodechatSession) []any {
+	v := reflect.ValueOf(s)
+	values := make([]any, v.NumField())
+	for i := range values {
+		values[i] = v.Field(i).Interface()
+	}
+	return values
+}
+
+// sessionsByID serves GetSessionBySessionId from sessions.
+func sessionsByID(sessions ...db.CodechatSession) func(args []any) ([][]any, error) {
+	return func(args []any) ([][]any, error) {
+		for _, s := range sessions {
+			if reflect.DeepEqual(s.SessionID, args[0]) {
+				return [][]any{sessionRow(s)}, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// newTestRegistry returns a registry backed by fdb, with API clients
+// pointing at cfg's URLs.
+func newTestRegistry(cfg *config.Config, fdb *fakeDB) *SessionRegistry {
+	r := &SessionRegistry{
+		cfg:        cfg,
+		db:         db.NewStore(fdb, nil),
+		ttl:        time.Minute,
+		httpClient: http.DefaultClient,
+		events:     NewSessionEvents(),
+		sent:       NewSentMessages(),
+		workers:    NewWorkers(),
+		entries:    make(map[string]*SessionEntry),
+	}
+	r.outbound = newOutboundQueue(r)
+	return r
+}
+
+// fakeAPI stands in for Chatwoot or Codechat. Requests are answered by the
+// route registered for "METHOD /path", or with an empty JSON object, and
+// recorded with their bodies.
+type fakeAPI struct {
+	mu       sync.Mutex
+	routes   map[string]http.HandlerFunc
+	requests []fakeRequest
+}
+
+type fakeRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   string
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{routes: make(map[string]http.HandlerFunc)}
+}
+
+func (f *fakeAPI) handle(route string, h http.HandlerFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.routes[route] = h
+}
+
+// reply answers a route with status and a JSON body.
+func (f *fakeAPI) reply(route string, status int, body string) {
+	f.handle(route, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	})
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	f.mu.Lock()
+	f.requests = append(f.requests, fakeRequest{r.Method, r.URL.Path, r.URL.Query(), r.Header, string(body)})
+	h, ok := f.routes[r.Method+" "+r.URL.Path]
+	f.mu.Unlock()
+	if ok {
+		h(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{}`))
+}
+
+// got returns the requests received for route, "METHOD /path".
+func (f *fakeAPI) got(route string) []fakeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []fakeRequest
+	for _, req := range f.requests {
+		if req.Method+" "+req.Path == route {
+			out = append(out, req)
+		}
+	}
+	return out
+}
+
+// newTestRelay returns the relay of session, talking to the fake APIs,
+// along with its database and registry.
+func newTestRelay(t *testing.T, session db.CodechatSession, cw, cc *fakeAPI) (*RelayService, *fakeDB, *SessionRegistry) {
+	t.Helper()
+	cwSrv := httptest.NewServer(cw)
+	t.Cleanup(cwSrv.Close)
+	ccSrv := httptest.NewServer(cc)
+	t.Cleanup(ccSrv.Close)
+
+	cfg := &config.Config{}
+	cfg.Server.URL = "https://bridge.example.com"
+	cfg.Chatwoot.URL = cwSrv.URL
+	cfg.Codechat.URL = ccSrv.URL
+	cfg.Relay.Timeout = 5 * time.Second
+	cfg.Relay.QueueTTL = time.Hour
+
+	fdb := newFakeDB()
+	fdb.handle("GetSessionBySessionId", sessionsByID(session))
+	registry := newTestRegistry(cfg, fdb)
+	relay, err := NewRelayService(context.Background(), cfg, registry, session.SessionID.String(), session.WebhookSecret)
+	if err != nil {
+		t.Fatalf("NewRelayService() error: %v", err)
+	}
+	return relay, fdb, registry
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sdrvirtual/codewoot/internal/codechat"
+	"github.com/sdrvirtual/codewoot/internal/db"
+	"github.com/sdrvirtual/codewoot/internal/domain"
+)
+
+// How often expired messages are dropped and stuck queues retried
+const outboundQueueInterval = time.Minute
+
+// Sessions waiting to be flushed by Run, past that signals are dropped and
+// the queues flushed on the next tick instead
+const outboundQueueWakeups = 64
+
+// OutboundQueue holds the replies from Chatwoot while the WhatsApp instance
+// of their session is disconnected, and sends them in order once it's open
+// again. Messages waiting longer than the TTL are dropped and the agent gets
+// a private note about it.
+type OutboundQueue struct {
+	db       *db.Store
+	registry *SessionRegistry
+	ttl      time.Duration
+
+	mu       sync.Mutex
+	flushing map[string]*sync.Mutex
+	wake     chan pgtype.UUID
+}
+
+type outboundPayload struct {
+	ChatwootMessageID int
+	Contact           domain.ContactInfo
+	Message           CodechatClientMessage
+}
+
+func newOutboundQueue(registry *SessionRegistry) *OutboundQueue {
+	return &OutboundQueue{
+		db:       registry.db,
+		registry: registry,
+		ttl:      registry.cfg.Relay.QueueTTL,
+		flushing: make(map[string]*sync.Mutex),
+		wake:     make(chan pgtype.UUID, outboundQueueWakeups),
+	}
+}
+
+// ShouldQueue reports whether a new message must wait: either the instance
+// is disconnected, or older messages are still waiting and it must not
+// overtake them.
+func (q *OutboundQueue) ShouldQueue(ctx context.Context, session pgtype.UUID) (bool, error) {
+	return q.db.ShouldQueueOutbound(ctx, session)
+}
+
+func (q *OutboundQueue) Enqueue(ctx context.Context, session pgtype.UUID, conversationID, messageID int, contact domain.ContactInfo, message CodechatClientMessage) error {
+	payload, err := json.Marshal(outboundPayload{
+		ChatwootMessageID: messageID,
+		Contact:           contact,
+		Message:           message,
+	})
+	if err != nil {
+		return err
+	}
+	return q.db.EnqueueOutboundMessage(ctx, db.EnqueueOutboundMessageParams{
+		SessionID:      session,
+		ConversationID: int32(conversationID),
+		Payload:        payload,
+		ExpiresAt:      pgtype.Timestamptz{Time: time.Now().Add(q.ttl), Valid: true},
+	})
+}
+
+func (q *OutboundQueue) lock(session string) *sync.Mutex {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	m, ok := q.flushing[session]
+	if !ok {
+		m = &sync.Mutex{}
+		q.flushing[session] = m
+	}
+	return m
+}
+
+// Flush sends the queued messages of the session in order, stopping at the
+// first one that fails so it's retried first. Messages Codechat rejects for
+// good, e.g. an invalid number, are marked as failed and skipped instead,
+// they would hold the queue until they expire. A flush already running for
+// the session picks up anything queued meanwhile, so this returns at once.
+func (q *OutboundQueue) Flush(ctx context.Context, session pgtype.UUID) error {
+	m := q.lock(session.String())
+	if !m.TryLock() {
+		return nil
+	}
+	defer m.Unlock()
+
+	for ctx.Err() == nil {
+		select {
+		case <-q.registry.workers.Stopping():
+			// Left for the next start
+			return nil
+		default:
+		}
+		msg, err := q.db.NextOutboundMessage(ctx, session)
+		if err != nil {
+			if err.Error() == "no rows in result set" {
+				return nil
+			}
+			return err
+		}
+		if time.Now().After(msg.ExpiresAt.Time) {
+			q.expire(ctx, msg)
+			continue
+		}
+
+		var payload outboundPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			log.Printf("session %s: dropping unreadable queued message %d: %v", session.String(), msg.ID, err)
+			if err := q.db.DeleteOutboundMessage(ctx, msg.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		entry, err := q.registry.Get(ctx, session.String())
+		if err != nil {
+			return err
+		}
+		sendCtx, cancel := context.WithTimeout(ctx, q.registry.cfg.Relay.Timeout)
+		keyID, err := entry.Codechat.SendMessage(sendCtx, payload.Contact, payload.Message)
+		cancel()
+		if err != nil && rejected(err) {
+			if err := q.db.DeleteOutboundMessage(ctx, msg.ID); err != nil {
+				return err
+			}
+			log.Printf("session %s: queued message %d not delivered: %v", session.String(), msg.ID, err)
+			q.notifyFailed(ctx, entry, msg, payload, deliveryFailureReason(err))
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("sending queued message %d: %w", msg.ID, err)
+		}
+		q.registry.Sent().Add(keyID)
+		if err := q.db.DeleteOutboundMessage(ctx, msg.ID); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// Signal asks Run to flush the queue of the session, without waiting for
+// it. Used when the instance connects again, the webhook telling so must
+// not wait for the whole queue to be sent.
+func (q *OutboundQueue) Signal(session pgtype.UUID) {
+	select {
+	case q.wake <- session:
+	default:
+		// Run is behind, the next tick flushes it
+	}
+}
+
+// expire tells the agent a queued message was given up on. It's already
+// deleted or about to be, so failures are only logged.
+func (q *OutboundQueue) expire(ctx context.Context, msg db.OutboundMessage) {
+	if err := q.db.DeleteOutboundMessage(ctx, msg.ID); err != nil {
+		log.Printf("session %s: error deleting expired message %d: %v", msg.SessionID.String(), msg.ID, err)
+		return
+	}
+	q.notifyExpired(ctx, msg)
+}
+
+func (q *OutboundQueue) notifyExpired(ctx context.Context, msg db.OutboundMessage) {
+	entry, err := q.registry.Get(ctx, msg.SessionID.String())
+	if err != nil {
+		log.Printf("session %s: error notifying expired message %d: %v", msg.SessionID.String(), msg.ID, err)
+		return
+	}
+	var payload outboundPayload
+	_ = json.Unmarshal(msg.Payload, &payload)
+	q.notifyFailed(ctx, entry, msg, payload, fmt.Sprintf("WhatsApp stayed disconnected for more than %s", q.ttl))
+}
+
+// notifyFailed tells the agent a queued message was given up on, and why.
+// Failures are only logged.
+func (q *OutboundQueue) notifyFailed(ctx context.Context, entry *SessionEntry, msg db.OutboundMessage, payload outboundPayload, reason string) {
+	var err error
+	if payload.ChatwootMessageID != 0 {
+		err = entry.Chatwoot.MarkMessageFailed(ctx, int(msg.ConversationID), payload.ChatwootMessageID, reason)
+	} else {
+		err = entry.Chatwoot.AddPrivateNote(ctx, int(msg.ConversationID), fmt.Sprintf("⚠️ This reply wasn't delivered, %s:\n\n%s", reason, payload.Message.Text))
+	}
+	if err != nil {
+		log.Printf("session %s: error notifying failed message %d: %v", msg.SessionID.String(), msg.ID, err)
+	}
+}
+
+// rejected reports whether Codechat refused a message for good. Timeouts,
+// rate limits and server errors are worth retrying.
+func rejected(err error) bool {
+	var apiErr *codechat.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return apiErr.StatusCode >= 400 && apiErr.StatusCode < 500
+}
+
+// Run drops expired messages, flushes the sessions signaled and retries the
+// queues of connected sessions until the workers stop or ctx is done. The
+// flushes running then finish the message they're sending.
+func (q *OutboundQueue) Run(ctx context.Context) {
+	t := time.NewTicker(outboundQueueInterval)
+	defer t.Stop()
+	// Signaled sessions are flushed side by side, a slow one must not hold
+	// the others back
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.registry.workers.Stopping():
+			return
+		case session := <-q.wake:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := q.Flush(ctx, session); err != nil {
+					log.Printf("session %s: error flushing queued messages: %v", session.String(), err)
+				}
+			}()
+		case <-t.C:
+			expired, err := q.db.DeleteExpiredOutboundMessages(ctx)
+			if err != nil {
+				log.Printf("error expiring queued messages: %v", err)
+			}
+			for _, msg := range expired {
+				q.notifyExpired(ctx, msg)
+			}
+
+			sessions, err := q.db.ListFlushableOutboundSessions(ctx)
+			if err != nil {
+				log.Printf("error listing queued messages: %v", err)
+				continue
+			}
+			for _, session := range sessions {
+				if err := q.Flush(ctx, session); err != nil {
+					log.Printf("session %s: error flushing queued messages: %v", session.String(), err)
+				}
+			}
+		}
+	}
+}
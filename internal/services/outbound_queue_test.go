@@ -0,0 +1,313 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sdrvirtual/codewoot/internal/db"
+	"github.com/sdrvirtual/codewoot/internal/domain"
+)
+
+// fakeOutbox serves the outbound_message queries of a fakeDB from memory.
+type fakeOutbox struct {
+	mu     sync.Mutex
+	nextID int32
+	msgs   []db.OutboundMessage
+	closed bool
+}
+
+func newFakeOutbox(fdb *fakeDB) *fakeOutbox {
+	o := &fakeOutbox{}
+	fdb.handle("EnqueueOutboundMessage", func(args []any) ([][]any, error) {
+		o.add(db.OutboundMessage{
+			SessionID:      args[0].(pgtype.UUID),
+			ConversationID: args[1].(int32),
+			Payload:        args[2].([]byte),
+			ExpiresAt:      args[3].(pgtype.Timestamptz),
+		})
+		return nil, nil
+	})
+	fdb.handle("NextOutboundMessage", func(args []any) ([][]any, error) {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		for _, m := range o.msgs {
+			if m.SessionID == args[0].(pgtype.UUID) {
+				return [][]any{{m.ID, m.SessionID, m.ConversationID, m.Payload, m.CreatedAt, m.ExpiresAt}}, nil
+			}
+		}
+		return nil, nil
+	})
+	fdb.handle("DeleteOutboundMessage", func(args []any) ([][]any, error) {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		for i, m := range o.msgs {
+			if m.ID == args[0].(int32) {
+				o.msgs = append(o.msgs[:i], o.msgs[i+1:]...)
+				break
+			}
+		}
+		return nil, nil
+	})
+	fdb.handle("ShouldQueueOutbound", func(args []any) ([][]any, error) {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		return [][]any{{o.closed || len(o.msgs) > 0}}, nil
+	})
+	return o
+}
+
+func (o *fakeOutbox) add(m db.OutboundMessage) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.nextID++
+	m.ID = o.nextID
+	m.CreatedAt = pgtype.Timestamptz{Time: time.Now(), Valid: true}
+	o.msgs = append(o.msgs, m)
+}
+
+func (o *fakeOutbox) len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.msgs)
+}
+
+// enqueueText queues a text reply to a contact of the session.
+func enqueueText(t *testing.T, q *OutboundQueue, session pgtype.UUID, messageID int, text string) {
+	t.Helper()
+	contact := domain.ContactInfo{Phone: "+5511988776655", JID: "5511988776655@s.whatsapp.net"}
+	if err := q.Enqueue(context.Background(), session, 3, messageID, contact, CodechatClientMessage{Text: text}); err != nil {
+		t.Fatalf("Enqueue() error: %v", err)
+	}
+}
+
+// sentTexts returns the texts sent through Codechat, in order.
+func sentTexts(t *testing.T, cc *fakeAPI) []string {
+	t.Helper()
+	var texts []string
+	for _, req := range cc.got("POST /message/sendText/inst") {
+		var body struct {
+			TextMessage struct {
+				Text string `json:"text"`
+			} `json:"textMessage"`
+		}
+		if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+			t.Fatalf("decoding sendText body: %v", err)
+		}
+		texts = append(texts, body.TextMessage.Text)
+	}
+	return texts
+}
+
+func TestOutboundQueue_Enqueue(t *testing.T) {
+	session := testSession(t, "3e8b1f6a-92d4-4c07-a5e1-7f0c2d9b4a68")
+	_, fdb, registry := newTestRelay(t, session, newFakeAPI(), newFakeAPI())
+	outbox := newFakeOutbox(fdb)
+	q := registry.Outbound()
+
+	enqueueText(t, q, session.SessionID, 42, "hello")
+
+	if outbox.len() != 1 {
+		t.Fatalf("queued %d messages, want 1", outbox.len())
+	}
+	msg := outbox.msgs[0]
+	if msg.SessionID != session.SessionID || msg.ConversationID != 3 {
+		t.Fatalf("queued for session %s conversation %d", msg.SessionID.String(), msg.ConversationID)
+	}
+	if ttl := time.Until(msg.ExpiresAt.Time); ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Fatalf("message expires in %s, want the 1h queue TTL", ttl)
+	}
+	var payload outboundPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		t.Fatalf("decoding payload: %v", err)
+	}
+	if payload.ChatwootMessageID != 42 || payload.Message.Text != "hello" || payload.Contact.JID != "5511988776655@s.whatsapp.net" {
+		t.Fatalf("payload = %+v", payload)
+	}
+}
+
+func TestOutboundQueue_ShouldQueue(t *testing.T) {
+	session := testSession(t, "3e8b1f6a-92d4-4c07-a5e1-7f0c2d9b4a68")
+	_, fdb, registry := newTestRelay(t, session, newFakeAPI(), newFakeAPI())
+	outbox := newFakeOutbox(fdb)
+	q := registry.Outbound()
+	ctx := context.Background()
+
+	if queue, err := q.ShouldQueue(ctx, session.SessionID); err != nil || queue {
+		t.Fatalf("ShouldQueue() = %v, %v on an open session with an empty queue", queue, err)
+	}
+	outbox.closed = true
+	if queue, _ := q.ShouldQueue(ctx, session.SessionID); !queue {
+		t.Fatal("ShouldQueue() = false while disconnected")
+	}
+	outbox.closed = false
+	enqueueText(t, q, session.SessionID, 1, "first")
+	if queue, _ := q.ShouldQueue(ctx, session.SessionID); !queue {
+		t.Fatal("ShouldQueue() = false with messages waiting, the new one would overtake them")
+	}
+}
+
+func TestOutboundQueue_Flush(t *testing.T) {
+	session := testSession(t, "3e8b1f6a-92d4-4c07-a5e1-7f0c2d9b4a68")
+	cc := newFakeAPI()
+	_, fdb, registry := newTestRelay(t, session, newFakeAPI(), cc)
+	outbox := newFakeOutbox(fdb)
+	q := registry.Outbound()
+
+	for i, text := range []string{"first", "second", "third"} {
+		enqueueText(t, q, session.SessionID, i+1, text)
+	}
+	if err := q.Flush(context.Background(), session.SessionID); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+	if got := strings.Join(sentTexts(t, cc), ","); got != "first,second,third" {
+		t.Fatalf("sent %q, want first,second,third", got)
+	}
+	if outbox.len() != 0 {
+		t.Fatalf("%d messages left in the queue", outbox.len())
+	}
+}
+
+func TestOutboundQueue_FlushStopsAtFailure(t *testing.T) {
+	session := testSession(t, "3e8b1f6a-92d4-4c07-a5e1-7f0c2d9b4a68")
+	cc := newFakeAPI()
+	var mu sync.Mutex
+	sends := 0
+	cc.handle("POST /message/sendText/inst", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		sends++
+		if sends == 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	})
+	_, fdb, registry := newTestRelay(t, session, newFakeAPI(), cc)
+	outbox := newFakeOutbox(fdb)
+	q := registry.Outbound()
+
+	for i, text := range []string{"first", "second", "third"} {
+		enqueueText(t, q, session.SessionID, i+1, text)
+	}
+	if err := q.Flush(context.Background(), session.SessionID); err == nil {
+		t.Fatal("Flush() succeeded with Codechat failing")
+	}
+	// The failed message stays first in line, the next one isn't sent
+	if got := strings.Join(sentTexts(t, cc), ","); got != "first,second" {
+		t.Fatalf("sent %q, want first,second", got)
+	}
+	if outbox.len() != 2 {
+		t.Fatalf("%d messages left in the queue, want 2", outbox.len())
+	}
+
+	if err := q.Flush(context.Background(), session.SessionID); err != nil {
+		t.Fatalf("retrying Flush() error: %v", err)
+	}
+	if got := strings.Join(sentTexts(t, cc), ","); got != "first,second,second,third" {
+		t.Fatalf("sent %q after the retry", got)
+	}
+}
+
+func TestOutboundQueue_FlushSkipsRejected(t *testing.T) {
+	session := testSession(t, "3e8b1f6a-92d4-4c07-a5e1-7f0c2d9b4a68")
+	cw, cc := newFakeAPI(), newFakeAPI()
+	var mu sync.Mutex
+	sends := 0
+	cc.handle("POST /message/sendText/inst", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		sends++
+		w.Header().Set("Content-Type", "application/json")
+		if sends == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"status":400,"error":"Bad Request","message":"number does not exist"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	})
+	_, fdb, registry := newTestRelay(t, session, cw, cc)
+	outbox := newFakeOutbox(fdb)
+	q := registry.Outbound()
+
+	enqueueText(t, q, session.SessionID, 1, "to nobody")
+	enqueueText(t, q, session.SessionID, 2, "next")
+	if err := q.Flush(context.Background(), session.SessionID); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+	if got := strings.Join(sentTexts(t, cc), ","); got != "to nobody,next" {
+		t.Fatalf("sent %q, the rejected message must not hold the queue", got)
+	}
+	failed := cw.got("PATCH /api/v1/accounts/1/conversations/3/messages/1")
+	if len(failed) != 1 || !strings.Contains(failed[0].Body, "number does not exist") {
+		t.Fatalf("rejected message not marked as failed with the reason: %v", failed)
+	}
+	if outbox.len() != 0 {
+		t.Fatalf("%d messages left in the queue", outbox.len())
+	}
+}
+
+func TestOutboundQueue_FlushExpired(t *testing.T) {
+	session := testSession(t, "3e8b1f6a-92d4-4c07-a5e1-7f0c2d9b4a68")
+	cw, cc := newFakeAPI(), newFakeAPI()
+	_, fdb, registry := newTestRelay(t, session, cw, cc)
+	outbox := newFakeOutbox(fdb)
+	q := registry.Outbound()
+
+	payload, _ := json.Marshal(outboundPayload{ChatwootMessageID: 9, Message: CodechatClientMessage{Text: "too late"}})
+	outbox.add(db.OutboundMessage{
+		SessionID:      session.SessionID,
+		ConversationID: 3,
+		Payload:        payload,
+		ExpiresAt:      pgtype.Timestamptz{Time: time.Now().Add(-time.Minute), Valid: true},
+	})
+	enqueueText(t, q, session.SessionID, 10, "in time")
+
+	if err := q.Flush(context.Background(), session.SessionID); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+	if got := strings.Join(sentTexts(t, cc), ","); got != "in time" {
+		t.Fatalf("sent %q, the expired message must be dropped", got)
+	}
+	if len(cw.got("PATCH /api/v1/accounts/1/conversations/3/messages/9")) != 1 {
+		t.Fatal("expired message wasn't marked as failed in Chatwoot")
+	}
+	if outbox.len() != 0 {
+		t.Fatalf("%d messages left in the queue", outbox.len())
+	}
+}
+
+func TestOutboundQueue_Signal(t *testing.T) {
+	session := testSession(t, "3e8b1f6a-92d4-4c07-a5e1-7f0c2d9b4a68")
+	cc := newFakeAPI()
+	sent := make(chan struct{}, 1)
+	cc.handle("POST /message/sendText/inst", func(w http.ResponseWriter, r *http.Request) {
+		sent <- struct{}{}
+		_, _ = w.Write([]byte(`{}`))
+	})
+	_, fdb, registry := newTestRelay(t, session, newFakeAPI(), cc)
+	newFakeOutbox(fdb)
+	q := registry.Outbound()
+	enqueueText(t, q, session.SessionID, 1, "hello")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(done)
+	}()
+	q.Signal(session.SessionID)
+
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("signaled queue wasn't flushed")
+	}
+	cancel()
+	<-done
+}
@@ -2,14 +2,17 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/jackc/pgx/v5/pgtype"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/sdrvirtual/codewoot/internal/chatwoot"
+	"github.com/sdrvirtual/codewoot/internal/codechat"
 	"github.com/sdrvirtual/codewoot/internal/config"
 	"github.com/sdrvirtual/codewoot/internal/db"
 	"github.com/sdrvirtual/codewoot/internal/domain"
@@ -19,117 +22,403 @@ import (
 
 type RelayService struct {
 	cfg      *config.Config
+	session  db.CodechatSession
 	codechat *CodechatService
 	chatwoot *ChatwootService
-	ctx      *context.Context
+	events   *SessionEvents
+	outbound *OutboundQueue
+	sent     *SentMessages
+	workers  *Workers
+	db       *db.Store
+	ctx      context.Context
 }
 
-func NewRelayService(ctx context.Context, cfg *config.Config, p *pgxpool.Pool, session string) (*RelayService, error) {
-	q := db.New(p)
-	var sessionUUID pgtype.UUID
-	err := sessionUUID.Scan(session)
-	if err != nil {
-		return nil, err
-	}
-	sessionObj, err := q.GetSessionBySessionId(ctx, sessionUUID)
+// NewRelayService returns the relay of a webhook call, once secret is the
+// one of the session. See SessionRegistry.Authenticate.
+func NewRelayService(ctx context.Context, cfg *config.Config, registry *SessionRegistry, session, secret string) (*RelayService, error) {
+	// The relay must not be aborted halfway when the webhook caller times
+	// out or hangs up, so it keeps the request values but not its cancellation.
+	ctx = context.WithoutCancel(ctx)
+
+	lookupCtx, cancel := context.WithTimeout(ctx, cfg.Relay.Timeout)
+	defer cancel()
+	entry, err := registry.Authenticate(lookupCtx, session, secret)
 	if err != nil {
-		if err.Error() == "no rows in result set" {
-			return nil, fmt.Errorf("session %s does not exist", session)
-		}
 		return nil, err
 	}
 
 	return &RelayService{
 		cfg:      cfg,
-		codechat: NewCodechatService(cfg, sessionObj),
-		chatwoot: NewChatwootService(cfg, sessionObj),
-		ctx:      &ctx,
+		session:  entry.Session,
+		codechat: entry.Codechat,
+		chatwoot: entry.Chatwoot,
+		events:   registry.Events(),
+		outbound: registry.Outbound(),
+		sent:     registry.Sent(),
+		workers:  registry.workers,
+		db:       registry.db,
+		ctx:      ctx,
 	}, nil
 }
 
+func (r *RelayService) VerifyChatwootSignature(signature, timestamp string, body []byte) error {
+	return VerifyChatwootSignature(r.session, signature, timestamp, body, time.Now())
+}
+
+// opContext bounds a single upstream call of the relay. Calls going through
+// ChatwootService are bounded by it instead, one timeout per API call.
+func (r *RelayService) opContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.ctx, r.cfg.Relay.Timeout)
+}
+
 func (r *RelayService) FromCodechat(payload dto.CodechatWebhook) error {
-	if payload.Data.IsGroup || payload.Data.KeyFromMe || payload.Event != "messages.upsert" {
+	settings := r.session.Settings
+	if !settings.HandlesEvent(payload.Event) {
+		return nil
+	}
+	switch payload.Event {
+	case dto.CodechatEventQRCodeUpdated:
+		r.events.Publish(r.session.SessionID.String(), SessionEvent{
+			Type:   SessionEventQRCode,
+			QRCode: payload.QRCode.Base64,
+			Code:   payload.QRCode.Code,
+		})
+		return nil
+	case dto.CodechatEventConnectionUpdate:
+		r.events.Publish(r.session.SessionID.String(), SessionEvent{
+			Type:         SessionEventConnection,
+			State:        payload.Connection.State,
+			StatusReason: payload.Connection.StatusReason,
+		})
+		return r.updateConnectionState(*payload.Connection)
+	}
+
+	if payload.Event != dto.CodechatEventMessagesUpsert {
+		return nil
+	}
+	data := payload.Data
+	if data.KeyFromMe && !settings.MirrorFromMe {
+		return nil
+	}
+	if data.KeyFromMe && r.sent.Sent(data.KeyID) {
+		// An agent reply the bridge sent, it's in Chatwoot already
 		return nil
 	}
 
-	if payload.Data.KeyRemoteJid == payload.Instance.OwnerJid {
+	if data.KeyRemoteJid == payload.Instance.OwnerJid {
 		// This should not happen, but it does ;(
 		return nil
 	}
 
 	// TODO: Handle deleting messages
 
-	phone, err := utils.ValidatePhone(strings.Split(payload.Data.KeyRemoteJid, "@")[0])
-	if err != nil {
-		return err
+	jid := data.KeyRemoteJid
+	if data.IsGroup {
+		if settings.Groups != domain.GroupsSender || data.KeyParticipant == "" {
+			return nil
+		}
+		jid = data.KeyParticipant
 	}
 	contact := domain.ContactInfo{
-		Name:  payload.Data.PushName,
-		Phone: "+" + phone,
+		Name:     data.PushName,
+		JID:      jid,
+		Metadata: whatsAppMetadata(data, payload.Instance.Name, jid),
+	}
+	// A LID hides the phone number, the contact is only known by its JID
+	if !strings.HasSuffix(jid, "@lid") {
+		phone, err := utils.ValidatePhone(strings.Split(jid, "@")[0])
+		if err != nil {
+			return err
+		}
+		contact.Phone = "+" + strings.TrimPrefix(phone, "+")
 	}
 
 	message := chatwoot.NewChatwootClientMessage()
+	if data.KeyFromMe {
+		// Sent from the phone, the push name and device are our own. A
+		// LID contact is left unnamed, Chatwoot shows its identifier.
+		contact.Name = contact.Phone
+		contact.Metadata.Device = ""
+		contact.Metadata.VerifiedBizName = ""
+		message.MessageType = dto.Outgoing
+		message.SourceID = mirroredSourcePrefix + data.KeyID
+	}
 
-	switch content := payload.Data.Content.(type) {
+	var text string
+	switch content := data.Content.(type) {
 	case dto.CodechatTextContent:
+		text = content.Text
 		message.Text = content.Text
 	case dto.CodechatAudioContent:
-		audioData, err := r.codechat.GetAudioContent(*r.ctx, &payload.Data)
+		if err := checkMediaSize(settings, content.FileLength); err != nil {
+			return err
+		}
+		ctx, cancel := r.opContext()
+		audioData, err := r.codechat.GetAudioContent(ctx, &data)
+		cancel()
 		if err != nil {
 			return err
 		}
 		message.FileType = "audio"
 		message.Attachment = audioData
 	case dto.CodechatImageContent:
-		return fmt.Errorf("received message with images")
+		if !settings.Media.Images {
+			return fmt.Errorf("received message with images")
+		}
+		if err := checkMediaSize(settings, content.FileLength); err != nil {
+			return err
+		}
+		ctx, cancel := r.opContext()
+		imageData, err := r.codechat.GetImageContent(ctx, &data)
+		cancel()
+		if err != nil {
+			return err
+		}
+		message.Text = content.Caption
+		message.Attachment = imageData
+	}
+
+	// Every Chatwoot call the message takes is bounded on its own
+	conversationID, err := r.chatwoot.SendMessage(r.ctx, contact, message)
+	if err != nil {
+		return err
 	}
 
-	return r.chatwoot.SendMessage(*r.ctx, contact, message)
+	if data.KeyFromMe {
+		return nil
+	}
+	if reply := settings.AutoReply(text); reply != "" {
+		// The customer message is in Chatwoot already, failing here would
+		// have Codechat retry and post it twice
+		if err := r.chatwoot.Reply(r.ctx, conversationID, reply); err != nil {
+			log.Printf("session %s: error sending auto reply: %v", r.session.SessionID.String(), err)
+		}
+	}
+	return nil
 }
 
-func (r *RelayService) FromChatwoot(payload dto.ChatwootWebhook) error {
-	if payload.Event != "message_created" || payload.MessageType != "outgoing" || payload.Private {
+func whatsAppMetadata(data dto.CodechatData, instance, jid string) *domain.WhatsAppMetadata {
+	m := &domain.WhatsAppMetadata{
+		JID:             jid,
+		Device:          data.Device,
+		VerifiedBizName: data.VerifiedBizName,
+		Instance:        instance,
+	}
+	if strings.HasSuffix(jid, "@lid") {
+		m.LID = jid
+	}
+	return m
+}
+
+// Prefix of the source_id of messages mirrored from the phone, replies
+// carrying it came from WhatsApp and must not be sent back
+const mirroredSourcePrefix = "WAID:"
+
+// checkMediaSize rejects media larger than the session allows, before
+// downloading it. fileLength is the size Codechat reports, in bytes.
+func checkMediaSize(settings domain.SessionSettings, fileLength string) error {
+	if settings.Media.MaxBytes <= 0 {
+		return nil
+	}
+	size, err := strconv.ParseInt(fileLength, 10, 64)
+	if err != nil {
+		// Unknown size, let it through
 		return nil
 	}
+	if size > settings.Media.MaxBytes {
+		return fmt.Errorf("media of %d bytes is over the %d bytes limit", size, settings.Media.MaxBytes)
+	}
+	return nil
+}
 
-	phone, err := utils.ValidatePhone(strings.TrimPrefix(payload.Conversation.Meta.Sender.PhoneNumber, "+"))
+// updateConnectionState records the connection change and tells the agents
+// in Chatwoot when WhatsApp disconnects or comes back. The agents are told,
+// and the queued replies sent, in the background, Codechat would retry the
+// whole webhook otherwise.
+func (r *RelayService) updateConnectionState(update dto.CodechatConnectionUpdate) error {
+	ctx, cancel := r.opContext()
+	defer cancel()
+	if err := r.db.CreateConnectionEvent(ctx, db.CreateConnectionEventParams{
+		SessionID:    r.session.SessionID,
+		State:        update.State,
+		StatusReason: int32(update.StatusReason),
+	}); err != nil {
+		return err
+	}
+	if update.State == ConnectionStateConnecting {
+		// Only kept in the history, the session stays in its last
+		// settled state so flapping doesn't notify agents.
+		return nil
+	}
+	prev, err := r.db.UpdateConnectionState(ctx, db.UpdateConnectionStateParams{
+		SessionID:       r.session.SessionID,
+		ConnectionState: update.State,
+	})
 	if err != nil {
 		return err
 	}
-	contact := domain.ContactInfo{
-		Name:  payload.Conversation.Meta.Sender.Name,
-		Phone: phone,
+
+	if notice := connectionNotice(prev, update.State); notice != "" {
+		session, chatwoot := r.session.SessionID.String(), r.chatwoot
+		r.workers.Go(func(ctx context.Context) {
+			if err := chatwoot.NotifyOpenConversations(ctx, notice); err != nil {
+				log.Printf("session %s: error notifying agents of the connection change: %v", session, err)
+			}
+		})
+	}
+	if update.State == ConnectionStateOpen {
+		r.outbound.Signal(r.session.SessionID)
+	}
+	return nil
+}
+
+// connectionNotice returns the note for agents when the instance goes from
+// prev to state, or "" when the change isn't worth one.
+func connectionNotice(prev, state string) string {
+	switch {
+	case prev == ConnectionStateOpen && state == ConnectionStateClose:
+		return "⚠️ WhatsApp is disconnected. Replies sent from here won't be delivered until the session is connected again."
+	case prev == ConnectionStateClose && state == ConnectionStateOpen:
+		return "✅ WhatsApp is connected again. Replies are being delivered."
+	}
+	return ""
+}
+
+func (r *RelayService) FromChatwoot(payload dto.ChatwootWebhook) error {
+	if payload.Event != "message_created" || payload.MessageType != "outgoing" || payload.Private {
+		return nil
+	}
+	if payload.SourceID != nil && strings.HasPrefix(*payload.SourceID, mirroredSourcePrefix) {
+		return nil
+	}
+
+	contact, err := replyContact(payload.Conversation)
+	if err != nil {
+		for _, m := range payload.Conversation.Messages {
+			if reportErr := r.reportFailure(payload.Conversation.ID, m.ID, err); reportErr != nil {
+				return errors.Join(err, reportErr)
+			}
+		}
+		return nil
 	}
 
 	// TODO: Handle deleting messages
 
 	for _, m := range payload.Conversation.Messages {
-		message := NewCodechatClientMessage()
+		err := r.relayToCodechat(payload.Conversation.ID, m, contact)
+		if err == nil {
+			continue
+		}
+		// Reported failures are the agent's to act on, the webhook
+		// itself was handled.
+		if reportErr := r.reportFailure(payload.Conversation.ID, m.ID, err); reportErr != nil {
+			return errors.Join(err, reportErr)
+		}
+		log.Printf("session %s: message %d not delivered: %v", r.session.SessionID.String(), m.ID, err)
+	}
+	return nil
+}
+
+// replyContact returns who a reply in the conversation goes to. The JID
+// the relay stored in Chatwoot is preferred, since agents may edit the
+// phone number, and the phone is only used for contacts created before.
+func replyContact(conv dto.CWConversation) (domain.ContactInfo, error) {
+	sender := conv.Meta.Sender
+	contact := domain.ContactInfo{Name: sender.Name}
 
-		if m.Content != nil {
-			message.Text = *m.Content
+	candidates := []string{conv.ContactInbox.SourceID}
+	if sender.Identifier != nil {
+		candidates = append(candidates, *sender.Identifier)
+	}
+	if jid, ok := sender.AdditionalAttributes["whatsapp_jid"].(string); ok {
+		candidates = append(candidates, jid)
+	}
+	for _, jid := range candidates {
+		if domain.IsUserJID(jid) {
+			contact.JID = jid
+			contact.Phone = sender.PhoneNumber
+			return contact, nil
 		}
+	}
+
+	phone, err := utils.ValidatePhone(strings.TrimPrefix(sender.PhoneNumber, "+"))
+	if err != nil {
+		return contact, fmt.Errorf("invalid phone number: %w", err)
+	}
+	contact.Phone = phone
+	return contact, nil
+}
+
+func (r *RelayService) relayToCodechat(conversationID int, m dto.CWMessage, contact domain.ContactInfo) error {
+	message := NewCodechatClientMessage()
 
-		for _, a := range m.Attachments {
-			switch a.FileType {
-			case "audio":
-				message.AudioURL = a.DataURL
-			case "image":
-				message.MediaURL = a.DataURL
-			case "file":
-				message.FileURL = a.DataURL
-				u, err := url.Parse(*a.DataURL)
-				if err != nil {
-					return err
-				}
-				filename := path.Base(u.Path)
-				message.AttachmentName = &filename
+	if m.Content != nil && *m.Content != "" {
+		message.Text = r.session.Settings.Sign(m.Sender.Name, *m.Content)
+	}
+
+	for _, a := range m.Attachments {
+		switch a.FileType {
+		case "audio":
+			message.AudioURL = a.DataURL
+		case "image":
+			message.MediaURL = a.DataURL
+		case "file":
+			message.FileURL = a.DataURL
+			u, err := url.Parse(*a.DataURL)
+			if err != nil {
+				return err
 			}
+			filename := path.Base(u.Path)
+			message.AttachmentName = &filename
 		}
+	}
 
-		if err := r.codechat.SendMessage(*r.ctx, contact, message); err != nil {
-			return err
-		}
+	return r.sendOrQueue(conversationID, m.ID, contact, message)
+}
+
+// reportFailure marks the Chatwoot message as failed with a reason the
+// agent can act on.
+func (r *RelayService) reportFailure(conversationID, messageID int, err error) error {
+	return r.chatwoot.MarkMessageFailed(r.ctx, conversationID, messageID, deliveryFailureReason(err))
+}
+
+// deliveryFailureReason turns a relay error into a sentence for agents,
+// preferring the reason Codechat gave.
+func deliveryFailureReason(err error) string {
+	var apiErr *codechat.APIError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Message != "":
+		return apiErr.Message
+	case errors.As(err, &apiErr):
+		return fmt.Sprintf("WhatsApp API answered with status %d", apiErr.StatusCode)
+	case errors.Is(err, context.DeadlineExceeded):
+		return "WhatsApp API didn't answer in time"
 	}
-	return nil
+	return err.Error()
+}
+
+// sendOrQueue sends the message, or queues it while the instance is
+// disconnected so it's sent once the connection is open again.
+func (r *RelayService) sendOrQueue(conversationID, messageID int, contact domain.ContactInfo, message CodechatClientMessage) error {
+	ctx, cancel := r.opContext()
+	defer cancel()
+
+	queue, err := r.outbound.ShouldQueue(ctx, r.session.SessionID)
+	if err != nil {
+		return err
+	}
+	if queue {
+		return r.outbound.Enqueue(ctx, r.session.SessionID, conversationID, messageID, contact, message)
+	}
+
+	keyID, sendErr := r.codechat.SendMessage(ctx, contact, message)
+	if sendErr == nil {
+		r.sent.Add(keyID)
+		return nil
+	}
+	// The connection.update closing the instance may have arrived meanwhile
+	if queue, err := r.outbound.ShouldQueue(ctx, r.session.SessionID); err == nil && queue {
+		return r.outbound.Enqueue(ctx, r.session.SessionID, conversationID, messageID, contact, message)
+	}
+	return sendErr
 }
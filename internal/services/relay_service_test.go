@@ -0,0 +1,467 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sdrvirtual/codewoot/internal/db"
+	"github.com/sdrvirtual/codewoot/internal/domain"
+	"github.com/sdrvirtual/codewoot/internal/dto"
+)
+
+func TestConnectionNotice(t *testing.T) {
+	tests := []struct {
+		prev, state string
+		want        bool
+	}{
+		{ConnectionStateOpen, ConnectionStateClose, true},
+		{ConnectionStateClose, ConnectionStateOpen, true},
+		{"", ConnectionStateOpen, false},
+		{"", ConnectionStateClose, false},
+		{ConnectionStateOpen, ConnectionStateOpen, false},
+		{ConnectionStateClose, ConnectionStateClose, false},
+	}
+	for _, tt := range tests {
+		if got := connectionNotice(tt.prev, tt.state) != ""; got != tt.want {
+			t.Errorf("connectionNotice(%q, %q) notifies = %v, want %v", tt.prev, tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestReplyContact(t *testing.T) {
+	jid := "5511988776655@s.whatsapp.net"
+	edited := "+1 555 0100"
+
+	conv := func(sourceID string, identifier *string, attrs map[string]any, phone string) dto.CWConversation {
+		var c dto.CWConversation
+		c.ContactInbox.SourceID = sourceID
+		c.Meta.Sender.Identifier = identifier
+		c.Meta.Sender.AdditionalAttributes = attrs
+		c.Meta.Sender.PhoneNumber = phone
+		return c
+	}
+	tests := []struct {
+		name     string
+		conv     dto.CWConversation
+		wantDest string
+		wantErr  bool
+	}{
+		{"source id", conv(jid, nil, nil, edited), jid, false},
+		{"identifier", conv("a1b2c3", &jid, nil, edited), jid, false},
+		{"stored jid", conv("a1b2c3", nil, map[string]any{"whatsapp_jid": jid}, edited), jid, false},
+		{"group jid ignored", conv("123-456@g.us", nil, nil, "+55 11 98877-6655"), "5511988776655", false},
+		{"phone fallback", conv("a1b2c3", nil, nil, "+55 11 98877-6655"), "5511988776655", false},
+		{"no destination", conv("a1b2c3", nil, nil, "123"), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contact, err := replyContact(tt.conv)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("replyContact() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && contact.Destination() != tt.wantDest {
+				t.Fatalf("Destination() = %q, want %q", contact.Destination(), tt.wantDest)
+			}
+		})
+	}
+}
+
+func TestRelayService_ConnectionNoticeInBackground(t *testing.T) {
+	session := testSession(t, "5c1d9a7e-3f2b-4e6a-8d4c-1b7e9f0a2c35")
+	cw, cc := newFakeAPI(), newFakeAPI()
+	// Chatwoot being down must not fail the webhook, Codechat would retry it
+	listed := make(chan struct{}, 1)
+	cw.handle("GET /api/v1/accounts/1/conversations", func(w http.ResponseWriter, r *http.Request) {
+		listed <- struct{}{}
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	relay, fdb, registry := newTestRelay(t, session, cw, cc)
+	fdb.handle("CreateConnectionEvent", func([]any) ([][]any, error) { return nil, nil })
+	fdb.handle("UpdateConnectionState", func([]any) ([][]any, error) {
+		return [][]any{{ConnectionStateOpen}}, nil
+	})
+
+	err := relay.FromCodechat(dto.CodechatWebhook{
+		Event:      dto.CodechatEventConnectionUpdate,
+		Connection: &dto.CodechatConnectionUpdate{State: ConnectionStateClose},
+	})
+	if err != nil {
+		t.Fatalf("FromCodechat() error: %v", err)
+	}
+	select {
+	case <-listed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("agents weren't notified of the disconnection")
+	}
+	if err := registry.workers.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error: %v", err)
+	}
+}
+
+const customerJID = "5511988776655@s.whatsapp.net"
+
+// withOpenConversation has cw find every contact by its identifier as
+// contact 5, with the open conversation 3 in the inbox.
+func withOpenConversation(cw *fakeAPI) {
+	cw.handle("POST /api/v1/accounts/1/contacts/filter", func(w http.ResponseWriter, r *http.Request) {
+		var filter struct {
+			Payload []struct {
+				Values []string `json:"values"`
+			} `json:"payload"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&filter)
+		contact := map[string]any{"id": 5, "identifier": filter.Payload[0].Values[0]}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"payload": []any{contact}})
+	})
+	cw.reply("GET /api/v1/accounts/1/contacts/5/conversations", http.StatusOK, `{"payload":[{"id":3,"inbox_id":7,"status":"open"}]}`)
+	cw.reply("POST /api/v1/accounts/1/conversations/3/messages", http.StatusOK, `{"id":100}`)
+}
+
+// postedMessages returns the form fields of the messages posted in
+// conversation 3, the attached file under "attachment".
+func postedMessages(t *testing.T, cw *fakeAPI) []map[string]string {
+	t.Helper()
+	var messages []map[string]string
+	for _, req := range cw.got("POST /api/v1/accounts/1/conversations/3/messages") {
+		_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if err != nil {
+			t.Fatalf("message content type: %v", err)
+		}
+		fields := map[string]string{}
+		mr := multipart.NewReader(strings.NewReader(req.Body), params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("reading message form: %v", err)
+			}
+			b, _ := io.ReadAll(part)
+			name := part.FormName()
+			if part.FileName() != "" {
+				name = "attachment"
+			}
+			fields[name] = string(b)
+		}
+		messages = append(messages, fields)
+	}
+	return messages
+}
+
+// upsert returns a messages.upsert webhook for data.
+func upsert(data dto.CodechatData) dto.CodechatWebhook {
+	return dto.CodechatWebhook{
+		Event:    dto.CodechatEventMessagesUpsert,
+		Instance: dto.CodechatInstance{Name: "inst", OwnerJid: "5511900001111@s.whatsapp.net"},
+		Data:     data,
+	}
+}
+
+func relaySession(t *testing.T, settings domain.SessionSettings) db.CodechatSession {
+	t.Helper()
+	session := testSession(t, "8a3c5e71-0d2f-4b9a-a6e4-93c1f7b20d58")
+	session.Settings = settings
+	return session
+}
+
+func TestRelayService_FromCodechatText(t *testing.T) {
+	cw := newFakeAPI()
+	withOpenConversation(cw)
+	relay, _, _ := newTestRelay(t, relaySession(t, domain.SessionSettings{}), cw, newFakeAPI())
+
+	err := relay.FromCodechat(upsert(dto.CodechatData{
+		KeyID:        "3EB0C1",
+		KeyRemoteJid: customerJID,
+		PushName:     "Ana",
+		Content:      dto.CodechatTextContent{Text: "hello"},
+	}))
+	if err != nil {
+		t.Fatalf("FromCodechat() error: %v", err)
+	}
+	messages := postedMessages(t, cw)
+	if len(messages) != 1 || messages[0]["content"] != "hello" || messages[0]["message_type"] != "incoming" {
+		t.Fatalf("posted %v", messages)
+	}
+}
+
+func TestRelayService_FromCodechatGroups(t *testing.T) {
+	group := dto.CodechatData{
+		KeyID:          "3EB0C2",
+		KeyRemoteJid:   "120363041234567890@g.us",
+		KeyParticipant: customerJID,
+		IsGroup:        true,
+		PushName:       "Ana",
+		Content:        dto.CodechatTextContent{Text: "hi all"},
+	}
+
+	t.Run("ignored", func(t *testing.T) {
+		cw := newFakeAPI()
+		withOpenConversation(cw)
+		relay, _, _ := newTestRelay(t, relaySession(t, domain.SessionSettings{}), cw, newFakeAPI())
+		if err := relay.FromCodechat(upsert(group)); err != nil {
+			t.Fatalf("FromCodechat() error: %v", err)
+		}
+		if messages := postedMessages(t, cw); len(messages) != 0 {
+			t.Fatalf("group message relayed: %v", messages)
+		}
+	})
+
+	t.Run("sender", func(t *testing.T) {
+		cw := newFakeAPI()
+		withOpenConversation(cw)
+		relay, _, _ := newTestRelay(t, relaySession(t, domain.SessionSettings{Groups: domain.GroupsSender}), cw, newFakeAPI())
+		if err := relay.FromCodechat(upsert(group)); err != nil {
+			t.Fatalf("FromCodechat() error: %v", err)
+		}
+		lookups := cw.got("POST /api/v1/accounts/1/contacts/filter")
+		if len(lookups) == 0 || !strings.Contains(lookups[0].Body, customerJID) {
+			t.Fatalf("contact not looked up by the member who sent it: %v", lookups)
+		}
+		if messages := postedMessages(t, cw); len(messages) != 1 || messages[0]["content"] != "hi all" {
+			t.Fatalf("posted %v", messages)
+		}
+	})
+}
+
+func TestRelayService_FromCodechatMirror(t *testing.T) {
+	fromMe := dto.CodechatData{
+		KeyID:        "3EB0C3",
+		KeyRemoteJid: customerJID,
+		KeyFromMe:    true,
+		PushName:     "Our Shop",
+		Content:      dto.CodechatTextContent{Text: "sent from the phone"},
+	}
+
+	t.Run("off", func(t *testing.T) {
+		cw := newFakeAPI()
+		withOpenConversation(cw)
+		relay, _, _ := newTestRelay(t, relaySession(t, domain.SessionSettings{}), cw, newFakeAPI())
+		if err := relay.FromCodechat(upsert(fromMe)); err != nil {
+			t.Fatalf("FromCodechat() error: %v", err)
+		}
+		if messages := postedMessages(t, cw); len(messages) != 0 {
+			t.Fatalf("message from the phone relayed: %v", messages)
+		}
+	})
+
+	t.Run("on", func(t *testing.T) {
+		cw := newFakeAPI()
+		withOpenConversation(cw)
+		relay, _, _ := newTestRelay(t, relaySession(t, domain.SessionSettings{MirrorFromMe: true}), cw, newFakeAPI())
+		if err := relay.FromCodechat(upsert(fromMe)); err != nil {
+			t.Fatalf("FromCodechat() error: %v", err)
+		}
+		messages := postedMessages(t, cw)
+		if len(messages) != 1 {
+			t.Fatalf("posted %d messages, want 1", len(messages))
+		}
+		if m := messages[0]; m["message_type"] != "outgoing" || m["source_id"] != mirroredSourcePrefix+"3EB0C3" {
+			t.Fatalf("posted %v, want an outgoing message with the WhatsApp source id", m)
+		}
+	})
+
+	t.Run("agent replies", func(t *testing.T) {
+		cw, cc := newFakeAPI(), newFakeAPI()
+		withOpenConversation(cw)
+		cc.reply("POST /message/sendText/inst", http.StatusCreated, `{"key":{"remoteJid":"5511988776655@s.whatsapp.net","fromMe":true,"id":"BAE5F2D1C0A9"}}`)
+		relay, fdb, _ := newTestRelay(t, relaySession(t, domain.SessionSettings{MirrorFromMe: true}), cw, cc)
+		fdb.handle("ShouldQueueOutbound", func([]any) ([][]any, error) { return [][]any{{false}}, nil })
+
+		content := "on my way"
+		var conv dto.CWConversation
+		conv.ID = 3
+		conv.ContactInbox.SourceID = customerJID
+		conv.Messages = []dto.CWMessage{{ID: 100, Content: &content}}
+		err := relay.FromChatwoot(dto.ChatwootWebhook{Event: "message_created", MessageType: dto.Outgoing, Conversation: conv})
+		if err != nil {
+			t.Fatalf("FromChatwoot() error: %v", err)
+		}
+		if len(cc.got("POST /message/sendText/inst")) != 1 {
+			t.Fatal("agent reply wasn't sent to WhatsApp")
+		}
+
+		// Codechat echoes the reply it sent, it's in Chatwoot already
+		echo := fromMe
+		echo.KeyID = "BAE5F2D1C0A9"
+		echo.Content = dto.CodechatTextContent{Text: content}
+		if err := relay.FromCodechat(upsert(echo)); err != nil {
+			t.Fatalf("FromCodechat() error: %v", err)
+		}
+		if messages := postedMessages(t, cw); len(messages) != 0 {
+			t.Fatalf("agent reply mirrored back: %v", messages)
+		}
+	})
+}
+
+func TestRelayService_FromCodechatImage(t *testing.T) {
+	image := dto.CodechatData{
+		KeyID:        "3EB0C4",
+		KeyRemoteJid: customerJID,
+		PushName:     "Ana",
+		Content:      dto.CodechatImageContent{Caption: "the receipt", FileLength: "10"},
+	}
+	newCodechat := func() *fakeAPI {
+		cc := newFakeAPI()
+		cc.handle("POST /chat/mediaData/inst", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Header().Set("Content-Disposition", `attachment; filename="receipt.jpg"`)
+			_, _ = w.Write([]byte("jpeg-bytes"))
+		})
+		return cc
+	}
+
+	t.Run("rejected", func(t *testing.T) {
+		cw, cc := newFakeAPI(), newCodechat()
+		withOpenConversation(cw)
+		relay, _, _ := newTestRelay(t, relaySession(t, domain.SessionSettings{}), cw, cc)
+		if err := relay.FromCodechat(upsert(image)); err == nil {
+			t.Fatal("image relayed with images off")
+		}
+		if len(cc.got("POST /chat/mediaData/inst")) != 0 {
+			t.Fatal("image downloaded with images off")
+		}
+	})
+
+	t.Run("relayed", func(t *testing.T) {
+		cw, cc := newFakeAPI(), newCodechat()
+		withOpenConversation(cw)
+		relay, _, _ := newTestRelay(t, relaySession(t, domain.SessionSettings{Media: domain.MediaSettings{Images: true, MaxBytes: 10}}), cw, cc)
+		if err := relay.FromCodechat(upsert(image)); err != nil {
+			t.Fatalf("FromCodechat() error: %v", err)
+		}
+		messages := postedMessages(t, cw)
+		if len(messages) != 1 || messages[0]["content"] != "the receipt" || messages[0]["attachment"] != "jpeg-bytes" {
+			t.Fatalf("posted %v", messages)
+		}
+	})
+
+	t.Run("over the size limit", func(t *testing.T) {
+		cw, cc := newFakeAPI(), newCodechat()
+		withOpenConversation(cw)
+		relay, _, _ := newTestRelay(t, relaySession(t, domain.SessionSettings{Media: domain.MediaSettings{Images: true, MaxBytes: 9}}), cw, cc)
+		if err := relay.FromCodechat(upsert(image)); err == nil {
+			t.Fatal("image over the limit relayed")
+		}
+		if len(cc.got("POST /chat/mediaData/inst")) != 0 {
+			t.Fatal("image over the limit downloaded")
+		}
+	})
+}
+
+func TestRelayService_FromCodechatAutoReply(t *testing.T) {
+	settings := domain.SessionSettings{AutoReplies: []domain.AutoReply{{Keyword: "hours", Reply: "We're open 9 to 5"}}}
+	question := dto.CodechatData{
+		KeyID:        "3EB0C5",
+		KeyRemoteJid: customerJID,
+		PushName:     "Ana",
+		Content:      dto.CodechatTextContent{Text: "What are your hours?"},
+	}
+
+	t.Run("replied", func(t *testing.T) {
+		cw := newFakeAPI()
+		withOpenConversation(cw)
+		relay, _, _ := newTestRelay(t, relaySession(t, settings), cw, newFakeAPI())
+		if err := relay.FromCodechat(upsert(question)); err != nil {
+			t.Fatalf("FromCodechat() error: %v", err)
+		}
+		messages := postedMessages(t, cw)
+		if len(messages) != 2 {
+			t.Fatalf("posted %d messages, want the question and the reply", len(messages))
+		}
+		if m := messages[1]; m["content"] != "We're open 9 to 5" || m["message_type"] != "outgoing" {
+			t.Fatalf("reply = %v", m)
+		}
+	})
+
+	t.Run("reply failing", func(t *testing.T) {
+		cw := newFakeAPI()
+		withOpenConversation(cw)
+		var mu sync.Mutex
+		posted := 0
+		cw.handle("POST /api/v1/accounts/1/conversations/3/messages", func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			posted++
+			if posted > 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"id":100}`))
+		})
+		relay, _, _ := newTestRelay(t, relaySession(t, settings), cw, newFakeAPI())
+		// A retry by Codechat would post the question a second time
+		if err := relay.FromCodechat(upsert(question)); err != nil {
+			t.Fatalf("FromCodechat() error: %v", err)
+		}
+	})
+}
+
+func TestRelayService_EventsSwitchedOff(t *testing.T) {
+	cw := newFakeAPI()
+	withOpenConversation(cw)
+	settings := domain.SessionSettings{Events: map[string]bool{domain.EventMessagesUpsert: false}}
+	relay, _, _ := newTestRelay(t, relaySession(t, settings), cw, newFakeAPI())
+
+	err := relay.FromCodechat(upsert(dto.CodechatData{
+		KeyID:        "3EB0C6",
+		KeyRemoteJid: customerJID,
+		Content:      dto.CodechatTextContent{Text: "hello"},
+	}))
+	if err != nil {
+		t.Fatalf("FromCodechat() error: %v", err)
+	}
+	if messages := postedMessages(t, cw); len(messages) != 0 {
+		t.Fatalf("switched off event relayed: %v", messages)
+	}
+}
+
+func TestRelayService_FromCodechatLID(t *testing.T) {
+	const lid = "201234567890123@lid"
+	cw := newFakeAPI()
+	cw.reply("POST /api/v1/accounts/1/contacts/filter", http.StatusOK, `{"payload":[]}`)
+	cw.reply("POST /api/v1/accounts/1/contacts", http.StatusOK, `{"payload":{"contact":{"id":6,"identifier":"`+lid+`"}}}`)
+	cw.reply("GET /api/v1/accounts/1/contacts/6/conversations", http.StatusOK, `{"payload":[{"id":3,"inbox_id":7,"status":"open"}]}`)
+	cw.reply("POST /api/v1/accounts/1/conversations/3/messages", http.StatusOK, `{"id":100}`)
+	relay, _, _ := newTestRelay(t, relaySession(t, domain.SessionSettings{}), cw, newFakeAPI())
+
+	err := relay.FromCodechat(upsert(dto.CodechatData{
+		KeyID:        "3EB0C7",
+		KeyRemoteJid: lid,
+		PushName:     "Ana",
+		Content:      dto.CodechatTextContent{Text: "hello"},
+	}))
+	if err != nil {
+		t.Fatalf("FromCodechat() error: %v", err)
+	}
+	if searches := cw.got("GET /api/v1/accounts/1/contacts/search"); len(searches) != 0 {
+		t.Fatalf("LID contact searched by phone: %v", searches[0].Query)
+	}
+	created := cw.got("POST /api/v1/accounts/1/contacts")
+	if len(created) != 1 {
+		t.Fatalf("created %d contacts, want 1", len(created))
+	}
+	var params map[string]any
+	if err := json.Unmarshal([]byte(created[0].Body), &params); err != nil {
+		t.Fatalf("decoding contact: %v", err)
+	}
+	if _, ok := params["phone_number"]; ok {
+		t.Fatalf("LID contact created with phone_number %v", params["phone_number"])
+	}
+	if params["identifier"] != lid {
+		t.Fatalf("identifier = %v, want %s", params["identifier"], lid)
+	}
+	if messages := postedMessages(t, cw); len(messages) != 1 {
+		t.Fatalf("posted %d messages, want 1", len(messages))
+	}
+}
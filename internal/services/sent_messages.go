@@ -0,0 +1,59 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// How long the key of a message sent for an agent is kept, Codechat
+	// posts its fromMe upsert within seconds
+	sentMessageTTL = 10 * time.Minute
+	// Caps the keys kept, the oldest are forgotten first
+	maxSentMessages = 10000
+)
+
+// SentMessages remembers the key IDs of the messages the bridge sent to
+// WhatsApp for agents, so their fromMe upserts aren't mirrored back into
+// Chatwoot, where the agent reply already is.
+type SentMessages struct {
+	mu     sync.Mutex
+	sentAt map[string]time.Time
+	// Keys in the order they were sent, to forget the oldest
+	order []string
+}
+
+func NewSentMessages() *SentMessages {
+	return &SentMessages{sentAt: make(map[string]time.Time)}
+}
+
+// Add records a sent message. Empty keys, when Codechat didn't return one,
+// are ignored.
+func (s *SentMessages) Add(keyID string) {
+	if keyID == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for len(s.order) > 0 {
+		oldest := s.order[0]
+		if len(s.order) < maxSentMessages && now.Sub(s.sentAt[oldest]) < sentMessageTTL {
+			break
+		}
+		delete(s.sentAt, oldest)
+		s.order = s.order[1:]
+	}
+	if _, ok := s.sentAt[keyID]; !ok {
+		s.order = append(s.order, keyID)
+	}
+	s.sentAt[keyID] = now
+}
+
+// Sent reports whether the bridge sent the message with this key lately.
+func (s *SentMessages) Sent(keyID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	at, ok := s.sentAt[keyID]
+	return ok && time.Since(at) < sentMessageTTL
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SessionEventQRCode     = "qrcode"
+	SessionEventConnection = "connection"
+
+	// Codechat connection states, connecting happens in between the others
+	ConnectionStateOpen       = "open"
+	ConnectionStateConnecting = "connecting"
+	ConnectionStateClose      = "close"
+
+	// WhatsApp rotates the QR code about this often, an older one can't
+	// be scanned anymore
+	qrCodeLifetime = 60 * time.Second
+)
+
+type SessionEvent struct {
+	Type string `json:"type"`
+	// Set on qrcode events, QRCode is the image as a data URL
+	QRCode string `json:"qrcode,omitempty"`
+	Code   string `json:"code,omitempty"`
+	// Set on connection events
+	State        string    `json:"state,omitempty"`
+	StatusReason int       `json:"status_reason,omitempty"`
+	At           time.Time `json:"at"`
+}
+
+// QRCodePNG decodes the data URL of a qrcode event.
+func (e SessionEvent) QRCodePNG() ([]byte, error) {
+	data := e.QRCode
+	if i := strings.Index(data, ","); strings.HasPrefix(data, "data:") && i >= 0 {
+		if !strings.HasPrefix(data, "data:image/png;base64,") {
+			return nil, fmt.Errorf("qrcode is not a base64 png")
+		}
+		data = data[i+1:]
+	}
+	return base64.StdEncoding.DecodeString(data)
+}
+
+// SessionEvents keeps the latest QR code of each session and fans the
+// pairing events out to the subscribers of that session. It lives in
+// memory, so every replica only sees the webhooks it received.
+type SessionEvents struct {
+	mu       sync.Mutex
+	closed   bool
+	sessions map[string]*sessionEvents
+}
+
+type sessionEvents struct {
+	qrcode *SessionEvent
+	subs   map[chan SessionEvent]struct{}
+}
+
+func NewSessionEvents() *SessionEvents {
+	return &SessionEvents{sessions: make(map[string]*sessionEvents)}
+}
+
+func (e *SessionEvents) get(session string) *sessionEvents {
+	s, ok := e.sessions[session]
+	if !ok {
+		s = &sessionEvents{subs: make(map[chan SessionEvent]struct{})}
+		e.sessions[session] = s
+	}
+	return s
+}
+
+// Publish records ev and delivers it to the subscribers of the session.
+// Slow subscribers miss events rather than blocking the webhook.
+func (e *SessionEvents) Publish(session string, ev SessionEvent) {
+	if ev.At.IsZero() {
+		ev.At = time.Now()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	s := e.get(session)
+	switch {
+	case ev.Type == SessionEventQRCode:
+		s.qrcode = &ev
+	case ev.Type == SessionEventConnection && ev.State == ConnectionStateOpen:
+		// Paired, the QR code can't be used anymore
+		s.qrcode = nil
+	}
+	for ch := range s.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+	if s.qrcode == nil && len(s.subs) == 0 {
+		delete(e.sessions, session)
+	}
+}
+
+// LatestQRCode returns the last QR code of the session while it can still
+// be scanned.
+func (e *SessionEvents) LatestQRCode(session string) (SessionEvent, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s, ok := e.sessions[session]
+	if !ok || s.qrcode == nil {
+		return SessionEvent{}, false
+	}
+	if time.Since(s.qrcode.At) > qrCodeLifetime {
+		s.qrcode = nil
+		if len(s.subs) == 0 {
+			delete(e.sessions, session)
+		}
+		return SessionEvent{}, false
+	}
+	return *s.qrcode, true
+}
+
+// Subscribe returns the events of the session from now on. The channel is
+// closed by the returned cancel func or when the server shuts down.
+func (e *SessionEvents) Subscribe(session string) (<-chan SessionEvent, func()) {
+	ch := make(chan SessionEvent, 8)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	s := e.get(session)
+	s.subs[ch] = struct{}{}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			if _, ok := s.subs[ch]; !ok {
+				return
+			}
+			delete(s.subs, ch)
+			close(ch)
+			if s.qrcode == nil && len(s.subs) == 0 && e.sessions[session] == s {
+				delete(e.sessions, session)
+			}
+		})
+	}
+}
+
+// Close ends every subscription, so open event streams don't hold up a
+// graceful shutdown.
+func (e *SessionEvents) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	for _, s := range e.sessions {
+		for ch := range s.subs {
+			delete(s.subs, ch)
+			close(ch)
+		}
+	}
+}
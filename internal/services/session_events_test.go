@@ -0,0 +1,76 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestSessionEventsQRCodeLifecycle(t *testing.T) {
+	e := NewSessionEvents()
+	ch, cancel := e.Subscribe("s1")
+	defer cancel()
+
+	e.Publish("s1", SessionEvent{Type: SessionEventQRCode, QRCode: "data:image/png;base64,iVBO"})
+	if ev := <-ch; ev.Type != SessionEventQRCode {
+		t.Fatalf("got %q event, want qrcode", ev.Type)
+	}
+	if _, ok := e.LatestQRCode("s1"); !ok {
+		t.Fatal("expected the QR code to be kept")
+	}
+	if _, ok := e.LatestQRCode("s2"); ok {
+		t.Fatal("QR code leaked to another session")
+	}
+
+	e.Publish("s1", SessionEvent{Type: SessionEventConnection, State: ConnectionStateOpen})
+	if ev := <-ch; ev.State != ConnectionStateOpen {
+		t.Fatalf("got state %q, want open", ev.State)
+	}
+	if _, ok := e.LatestQRCode("s1"); ok {
+		t.Fatal("expected the QR code to be dropped once connected")
+	}
+}
+
+func TestSessionEventsQRCodeExpires(t *testing.T) {
+	e := NewSessionEvents()
+	e.Publish("s1", SessionEvent{
+		Type:   SessionEventQRCode,
+		QRCode: "data:image/png;base64,iVBO",
+		At:     time.Now().Add(-qrCodeLifetime - time.Second),
+	})
+	if _, ok := e.LatestQRCode("s1"); ok {
+		t.Fatal("expected an expired QR code not to be returned")
+	}
+
+	e.Publish("s1", SessionEvent{Type: SessionEventQRCode, QRCode: "data:image/png;base64,iVBO"})
+	if _, ok := e.LatestQRCode("s1"); !ok {
+		t.Fatal("expected a fresh QR code to be returned")
+	}
+}
+
+func TestSessionEventsClose(t *testing.T) {
+	e := NewSessionEvents()
+	ch, cancel := e.Subscribe("s1")
+	e.Close()
+	if _, ok := <-ch; ok {
+		t.Fatal("expected the subscription to be closed")
+	}
+	// Cancelling after Close must not close the channel twice
+	cancel()
+	e.Publish("s1", SessionEvent{Type: SessionEventQRCode})
+}
+
+func TestQRCodePNG(t *testing.T) {
+	png := []byte{0x89, 'P', 'N', 'G'}
+	b64 := base64.StdEncoding.EncodeToString(png)
+	for _, qr := range []string{"data:image/png;base64," + b64, b64} {
+		got, err := SessionEvent{QRCode: qr}.QRCodePNG()
+		if err != nil || !bytes.Equal(got, png) {
+			t.Fatalf("QRCodePNG(%q) = %v, %v", qr, got, err)
+		}
+	}
+	if _, err := (SessionEvent{QRCode: "data:image/jpeg;base64," + b64}).QRCodePNG(); err == nil {
+		t.Fatal("expected an error for a non png data URL")
+	}
+}
@@ -0,0 +1,242 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sdrvirtual/codewoot/internal/chatwoot"
+	"github.com/sdrvirtual/codewoot/internal/codechat"
+	"github.com/sdrvirtual/codewoot/internal/config"
+	"github.com/sdrvirtual/codewoot/internal/db"
+)
+
+// SessionRegistry caches loaded sessions together with their API clients,
+// so webhooks don't hit Postgres and build new clients on every call.
+type SessionRegistry struct {
+	cfg        *config.Config
+	db         *db.Store
+	ttl        time.Duration
+	httpClient *http.Client
+	events     *SessionEvents
+	outbound   *OutboundQueue
+	sent       *SentMessages
+	workers    *Workers
+
+	mu      sync.RWMutex
+	entries map[string]*SessionEntry
+}
+
+type SessionEntry struct {
+	Session  db.CodechatSession
+	Codechat *CodechatService
+	Chatwoot *ChatwootService
+
+	expiresAt time.Time
+}
+
+// NewSessionRegistry returns an empty registry. Work the relays hand off to
+// the background, like notifying agents, runs on workers.
+func NewSessionRegistry(cfg *config.Config, p *pgxpool.Pool, workers *Workers) *SessionRegistry {
+	r := &SessionRegistry{
+		cfg:        cfg,
+		db:         db.NewStore(p, cfg.Encryption.Keys),
+		ttl:        cfg.Sessions.CacheTTL,
+		httpClient: newHTTPClient(),
+		events:     NewSessionEvents(),
+		sent:       NewSentMessages(),
+		workers:    workers,
+		entries:    make(map[string]*SessionEntry),
+	}
+	r.outbound = newOutboundQueue(r)
+	return r
+}
+
+// newHTTPClient returns the client shared by every cached session. Most
+// sessions talk to the same Chatwoot and Codechat hosts, so keeping more
+// idle connections per host avoids a new TLS handshake per message.
+func newHTTPClient() *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = 200
+	t.MaxIdleConnsPerHost = 50
+	t.IdleConnTimeout = 90 * time.Second
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: t,
+	}
+}
+
+func (r *SessionRegistry) HTTPClient() *http.Client {
+	return r.httpClient
+}
+
+func (r *SessionRegistry) Events() *SessionEvents {
+	return r.events
+}
+
+func (r *SessionRegistry) Outbound() *OutboundQueue {
+	return r.outbound
+}
+
+func (r *SessionRegistry) Sent() *SentMessages {
+	return r.sent
+}
+
+func (r *SessionRegistry) Get(ctx context.Context, session string) (*SessionEntry, error) {
+	var sessionUUID pgtype.UUID
+	if err := sessionUUID.Scan(session); err != nil {
+		return nil, err
+	}
+	if e, ok := r.cached(sessionUUID); ok {
+		return e, nil
+	}
+
+	sessionObj, err := r.db.GetSessionBySessionId(ctx, sessionUUID)
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			r.Invalidate(session)
+			return nil, fmt.Errorf("session %s does not exist", session)
+		}
+		return nil, err
+	}
+	return r.load(sessionObj)
+}
+
+// Authenticate returns the session a webhook is for once the secret the
+// caller sent matches. Unknown sessions fail with the same
+// ErrInvalidWebhookSecret as a wrong secret, and nothing else about the
+// session is checked before, so callers can't probe which sessions exist.
+func (r *SessionRegistry) Authenticate(ctx context.Context, session, secret string) (*SessionEntry, error) {
+	var sessionUUID pgtype.UUID
+	if err := sessionUUID.Scan(session); err != nil {
+		return nil, ErrInvalidWebhookSecret
+	}
+	if e, ok := r.cached(sessionUUID); ok {
+		if err := VerifyWebhookSecret(e.Session, secret); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+
+	sessionObj, err := r.db.GetSessionBySessionId(ctx, sessionUUID)
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			r.Invalidate(session)
+			return nil, ErrInvalidWebhookSecret
+		}
+		return nil, err
+	}
+	if err := VerifyWebhookSecret(sessionObj, secret); err != nil {
+		return nil, err
+	}
+	return r.load(sessionObj)
+}
+
+func (r *SessionRegistry) cached(session pgtype.UUID) (*SessionEntry, bool) {
+	r.mu.RLock()
+	e, ok := r.entries[session.String()]
+	r.mu.RUnlock()
+	if ok && time.Now().Before(e.expiresAt) {
+		return e, true
+	}
+	return nil, false
+}
+
+// load builds the API clients of the session and caches them.
+func (r *SessionRegistry) load(sessionObj db.CodechatSession) (*SessionEntry, error) {
+	session := sessionObj.SessionID.String()
+	codechatSvc, err := NewCodechatService(r.cfg, sessionObj, codechat.WithHTTPClient(r.httpClient))
+	if err != nil {
+		return nil, fmt.Errorf("session %s is misconfigured: %w", session, err)
+	}
+	chatwootSvc, err := NewChatwootService(r.cfg, r.db, sessionObj, chatwoot.WithHTTPClient(r.httpClient))
+	if err != nil {
+		return nil, fmt.Errorf("session %s is misconfigured: %w", session, err)
+	}
+	chatwootSvc.profiles = codechatSvc
+	e := &SessionEntry{
+		Session:   sessionObj,
+		Codechat:  codechatSvc,
+		Chatwoot:  chatwootSvc,
+		expiresAt: time.Now().Add(r.ttl),
+	}
+
+	r.mu.Lock()
+	r.entries[session] = e
+	r.mu.Unlock()
+	return e, nil
+}
+
+// Run evicts expired entries until the workers stop or ctx is done, so
+// sessions that stopped receiving webhooks don't stay cached forever.
+func (r *SessionRegistry) Run(ctx context.Context) {
+	if r.ttl <= 0 {
+		return
+	}
+	t := time.NewTicker(r.ttl)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.workers.Stopping():
+			return
+		case now := <-t.C:
+			r.mu.Lock()
+			for k, e := range r.entries {
+				if now.After(e.expiresAt) {
+					delete(r.entries, k)
+				}
+			}
+			r.mu.Unlock()
+		}
+	}
+}
+
+// Invalidate drops a cached session, it must be called whenever the
+// session row is updated or deleted.
+func (r *SessionRegistry) Invalidate(session string) {
+	key := session
+	var sessionUUID pgtype.UUID
+	if err := sessionUUID.Scan(session); err == nil {
+		key = sessionUUID.String()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, key)
+}
+
+// ValidateSession reports why the API clients for a session can't be
+// built, or nil when the session is usable.
+func ValidateSession(cfg *config.Config, session db.CodechatSession) error {
+	if _, err := NewCodechatService(cfg, session); err != nil {
+		return err
+	}
+	if _, err := NewChatwootService(cfg, nil, session); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateSessions checks every stored session and returns the broken ones
+// keyed by session ID, including those whose tokens can't be decrypted.
+func ValidateSessions(ctx context.Context, cfg *config.Config, p *pgxpool.Pool) (map[string]error, error) {
+	sessions, broken, err := db.NewStore(p, cfg.Encryption.Keys).ListSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sessions {
+		if _, ok := broken[s.SessionID.String()]; ok {
+			continue
+		}
+		if err := ValidateSession(cfg, s); err != nil {
+			broken[s.SessionID.String()] = err
+		}
+	}
+	return broken, nil
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sdrvirtual/codewoot/internal/config"
+	"github.com/sdrvirtual/codewoot/internal/db"
+)
+
+func testSession(t *testing.T, id string) db.CodechatSession {
+	t.Helper()
+	var sessionID pgtype.UUID
+	if err := sessionID.Scan(id); err != nil {
+		t.Fatalf("scan uuid: %v", err)
+	}
+	return db.CodechatSession{
+		SessionID:              sessionID,
+		CodechatInstance:       "inst",
+		CodechatInstcanceToken: "cc-token",
+		ChatwootToken:          "cw-token",
+		ChatwootAccountID:      1,
+		ChatwootInboxID:        7,
+		WebhookSecret:          "s3cr3t",
+	}
+}
+
+func TestSessionRegistry_Authenticate(t *testing.T) {
+	session := testSession(t, "b1f2f0f4-7930-4e90-9b6f-8c0f3c9b6c12")
+	// Broken, but that must not show before the secret is checked
+	misconfigured := testSession(t, "0e4c6a52-27d5-4c8e-9f43-5d0f4b1e2a77")
+	misconfigured.ChatwootToken = ""
+
+	fdb := newFakeDB()
+	fdb.handle("GetSessionBySessionId", sessionsByID(session, misconfigured))
+	cfg := &config.Config{}
+	cfg.Codechat.URL = "http://codechat.invalid"
+	cfg.Chatwoot.URL = "http://chatwoot.invalid"
+	r := newTestRegistry(cfg, fdb)
+
+	tests := []struct {
+		name    string
+		session string
+		secret  string
+	}{
+		{"unknown session", "6f1d3c0e-8a4b-4f9e-b2d7-1c5e9a0f3b44", "s3cr3t"},
+		{"malformed session", "not-a-uuid", "s3cr3t"},
+		{"wrong secret", session.SessionID.String(), "wrong"},
+		{"no secret", session.SessionID.String(), ""},
+		{"misconfigured session", misconfigured.SessionID.String(), "wrong"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := r.Authenticate(context.Background(), tt.session, tt.secret)
+			if !errors.Is(err, ErrInvalidWebhookSecret) || err.Error() != ErrInvalidWebhookSecret.Error() {
+				t.Fatalf("Authenticate() error = %v, want exactly ErrInvalidWebhookSecret", err)
+			}
+		})
+	}
+
+	e, err := r.Authenticate(context.Background(), session.SessionID.String(), "s3cr3t")
+	if err != nil {
+		t.Fatalf("Authenticate() error: %v", err)
+	}
+	if e.Session.SessionID != session.SessionID {
+		t.Fatalf("Authenticate() returned session %s", e.Session.SessionID.String())
+	}
+	// Served from the cache now, the secret is still checked
+	if _, err := r.Authenticate(context.Background(), session.SessionID.String(), "wrong"); !errors.Is(err, ErrInvalidWebhookSecret) {
+		t.Fatalf("cached Authenticate() error = %v, want ErrInvalidWebhookSecret", err)
+	}
+}
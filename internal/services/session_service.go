@@ -2,23 +2,40 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net/url"
-	"path"
+	"log"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sdrvirtual/codewoot/internal/auth"
+	"github.com/sdrvirtual/codewoot/internal/chatwoot"
 	"github.com/sdrvirtual/codewoot/internal/codechat"
 	"github.com/sdrvirtual/codewoot/internal/config"
 	"github.com/sdrvirtual/codewoot/internal/db"
+	"github.com/sdrvirtual/codewoot/internal/domain"
+	"github.com/sdrvirtual/codewoot/internal/dto"
+	"github.com/sdrvirtual/codewoot/internal/utils"
 )
 
+// ErrInstanceTaken is returned when the Codechat instance of a new session
+// is already bridged by another one.
+var ErrInstanceTaken = errors.New("codechat instance already belongs to a session")
+
+// Unique index on codechat_session.codechat_instance
+const instanceUniqueIndex = "codechat_session_codechat_instance_key"
+
 type SessionService struct {
-	cfg    *config.Config
-	client *codechat.Client
-	ctx    *context.Context
-	db     *db.Queries
+	cfg      *config.Config
+	client   *codechat.Client
+	ctx      *context.Context
+	db       *db.Store
+	registry *SessionRegistry
 }
 
 type Option func(*SessionService) error
@@ -35,12 +52,21 @@ func WithInstance(instanceToken string, instance string) Option {
 	}
 }
 
+// WithRegistry makes the service reach the Codechat instances of stored
+// sessions through the clients cached in registry.
+func WithRegistry(registry *SessionRegistry) Option {
+	return func(c *SessionService) error {
+		c.registry = registry
+		return nil
+	}
+}
+
 func NewSessionService(ctx context.Context, cfg *config.Config, p *pgxpool.Pool, opts ...Option) (*SessionService, error) {
 	client, err := codechat.New(cfg.Codechat.URL, cfg.Codechat.GlobalToken)
 	if err != nil {
 		return nil, err
 	}
-	db := db.New(p)
+	db := db.NewStore(p, cfg.Encryption.Keys)
 	s := &SessionService{
 		cfg:    cfg,
 		client: client,
@@ -57,12 +83,16 @@ func NewSessionService(ctx context.Context, cfg *config.Config, p *pgxpool.Pool,
 	return s, nil
 }
 
-func (s *SessionService) CreateSession(instanceID *string, description *string, token string, inboxID, accountID int) (*db.CodechatSession, error) {
+// CreateSession creates the Codechat instance, or adopts the one given in
+// payload.Codechat, stores the session and points the instance webhook at
+// it. With create_inbox set, the Chatwoot API inbox is created too, already
+// pointing at the session webhook. When a step fails, what the previous ones
+// created is removed again, an adopted instance is left as it was.
+func (s *SessionService) CreateSession(payload dto.CreateSession) (_ *db.CodechatSession, err error) {
 	var sessionUUID pgtype.UUID
-	var err error
 
-	if instanceID != nil {
-		err = sessionUUID.Scan(*instanceID)
+	if payload.SessionID != nil {
+		err = sessionUUID.Scan(*payload.SessionID)
 	} else {
 		u, _ := uuid.NewUUID()
 		err = sessionUUID.Scan(u.String())
@@ -70,6 +100,9 @@ func (s *SessionService) CreateSession(instanceID *string, description *string,
 	if err != nil {
 		return nil, err
 	}
+	if payload.Chatwoot.CreateInbox && payload.Chatwoot.InboxID != 0 {
+		return nil, fmt.Errorf("inbox_id can't be set together with create_inbox")
+	}
 
 	_, err = s.db.GetSessionBySessionId(*s.ctx, sessionUUID)
 	if err == nil {
@@ -78,26 +111,292 @@ func (s *SessionService) CreateSession(instanceID *string, description *string,
 	if err.Error() != "no rows in result set" {
 		return nil, err
 	}
-	cip := &codechat.CreateInstanceParams{
-		InstanceName: sessionUUID.String(),
-		Description:  *description,
+	var settings domain.SessionSettings
+	if payload.Settings != nil {
+		settings = *payload.Settings
+	}
+	if err = settings.Validate(); err != nil {
+		return nil, err
+	}
+	if payload.Codechat != nil {
+		if err = s.checkImportInstance(*payload.Codechat); err != nil {
+			return nil, err
+		}
+	}
+	var desc string
+	if payload.Description != nil {
+		desc = *payload.Description
 	}
-	instance, err := s.client.CreateInstance(*s.ctx, *cip)
+	secret, err := NewWebhookSecret()
 	if err != nil {
 		return nil, err
 	}
+	var chatwootHmacSecret pgtype.Text
+	if hmacSecret := payload.Chatwoot.WebhookHMACSecret; hmacSecret != nil && *hmacSecret != "" {
+		chatwootHmacSecret = pgtype.Text{String: *hmacSecret, Valid: true}
+	}
+
+	inboxID := payload.Chatwoot.InboxID
+	if payload.Chatwoot.CreateInbox {
+		var client *chatwoot.Client
+		var inbox *dto.CWInbox
+		client, err = chatwoot.New(s.cfg.Chatwoot.URL, payload.Chatwoot.Token, payload.Chatwoot.AccountID)
+		if err != nil {
+			return nil, err
+		}
+		inbox, err = s.createInbox(client, db.CodechatSession{
+			SessionID:     sessionUUID,
+			WebhookSecret: secret,
+			Description:   desc,
+		}, payload.Chatwoot.InboxAgentIDs)
+		if err != nil {
+			return nil, fmt.Errorf("creating chatwoot inbox: %w", err)
+		}
+		inboxID = inbox.ID
+		// err is the named result, set by any failure below
+		defer func() {
+			if err == nil {
+				return
+			}
+			ctx, cancel := s.rollbackContext()
+			defer cancel()
+			if delErr := client.DeleteInbox(ctx, inbox.ID); delErr != nil {
+				log.Printf("session %s: error removing chatwoot inbox %d: %v", sessionUUID.String(), inbox.ID, delErr)
+			}
+		}()
+	}
+
+	var instanceName, instanceToken string
+	if payload.Codechat != nil {
+		instanceName, instanceToken = payload.Codechat.Instance, payload.Codechat.Token
+	} else {
+		var instance *codechat.CreateInstanceResponse
+		instance, err = s.client.CreateInstance(*s.ctx, codechat.CreateInstanceParams{
+			InstanceName: sessionUUID.String(),
+			Description:  desc,
+		})
+		if err != nil {
+			return nil, err
+		}
+		instanceName, instanceToken = instance.Name, instance.Auth.Token
+	}
+	instanceClient, err := codechat.New(
+		s.cfg.Codechat.URL,
+		s.cfg.Codechat.GlobalToken,
+		codechat.WithInstanceToken(instanceToken, instanceName),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if payload.Codechat == nil {
+		defer func() {
+			if err == nil {
+				return
+			}
+			ctx, cancel := s.rollbackContext()
+			defer cancel()
+			if _, delErr := instanceClient.DeleteInstance(ctx); delErr != nil {
+				log.Printf("session %s: error removing codechat instance %s: %v", sessionUUID.String(), instanceName, delErr)
+			}
+		}()
+	}
+
 	session, err := s.db.CreateSession(*s.ctx, db.CreateSessionParams{
 		SessionID:              sessionUUID,
-		ChatwootToken:          token,
+		ChatwootToken:          payload.Chatwoot.Token,
 		ChatwootInboxID:        int32(inboxID),
-		ChatwootAccountID:      int32(accountID),
-		CodechatInstance:       instance.Name,
-		CodechatInstcanceToken: instance.Auth.Token,
+		ChatwootAccountID:      int32(payload.Chatwoot.AccountID),
+		CodechatInstance:       instanceName,
+		CodechatInstcanceToken: instanceToken,
+		WebhookSecret:          secret,
+		ChatwootHmacSecret:     chatwootHmacSecret,
+		Description:            desc,
+		Settings:               settings,
+		Imported:               payload.Codechat != nil,
 	})
+	if err != nil {
+		// Imported meanwhile by a concurrent request
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == instanceUniqueIndex {
+			return nil, fmt.Errorf("%w: %s", ErrInstanceTaken, instanceName)
+		}
+		return nil, err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		ctx, cancel := s.rollbackContext()
+		defer cancel()
+		if delErr := s.db.DeleteSessionBySessionId(ctx, sessionUUID); delErr != nil {
+			log.Printf("session %s: error removing session: %v", sessionUUID.String(), delErr)
+		}
+	}()
+
+	webhookURL, err := CodechatWebhookURL(s.cfg, session)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = instanceClient.SetWebhook(*s.ctx, codechat.NewSetWebhookParams(webhookURL)); err != nil {
+		return nil, fmt.Errorf("configuring webhook: %w", err)
+	}
+	return &session, nil
+}
+
+// Bounds each step undoing a failed CreateSession
+const rollbackTimeout = 30 * time.Second
+
+// rollbackContext outlives the request, a client that went away mustn't
+// leave the inbox, instance or row of a failed CreateSession behind.
+func (s *SessionService) rollbackContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(*s.ctx), rollbackTimeout)
+}
+
+// checkImportInstance makes sure an existing instance can be adopted: the
+// token must be valid for it and no other session may bridge it already.
+func (s *SessionService) checkImportInstance(i dto.ImportInstance) error {
+	if i.Instance == "" || i.Token == "" {
+		return fmt.Errorf("codechat instance and token are required to import an instance")
+	}
+	taken, err := s.db.InstanceHasSession(*s.ctx, i.Instance)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return fmt.Errorf("%w: %s", ErrInstanceTaken, i.Instance)
+	}
+	client, err := codechat.New(
+		s.cfg.Codechat.URL,
+		s.cfg.Codechat.GlobalToken,
+		codechat.WithInstanceToken(i.Token, i.Instance),
+	)
+	if err != nil {
+		return err
+	}
+	instance, err := client.FetchInstance(*s.ctx)
+	if err != nil {
+		return fmt.Errorf("fetching codechat instance %s: %w", i.Instance, err)
+	}
+	if instance.Name != i.Instance {
+		return fmt.Errorf("codechat returned instance %q instead of %q", instance.Name, i.Instance)
+	}
+	return nil
+}
+
+// createInbox creates an API channel inbox named after the session
+// description, with the session webhook set and the agents assigned.
+func (s *SessionService) createInbox(client *chatwoot.Client, session db.CodechatSession, agentIDs []int) (*dto.CWInbox, error) {
+	webhookURL, err := ChatwootWebhookURL(s.cfg, session)
+	if err != nil {
+		return nil, err
+	}
+	name := session.Description
+	if name == "" {
+		name = "WhatsApp " + session.SessionID.String()[:8]
+	}
+	inbox, err := client.CreateInbox(*s.ctx, chatwoot.NewAPIInboxParams(name, webhookURL))
 	if err != nil {
 		return nil, err
 	}
-	return &session, err
+	if len(agentIDs) > 0 {
+		if err := client.AddInboxMembers(*s.ctx, inbox.ID, agentIDs); err != nil {
+			if delErr := client.DeleteInbox(*s.ctx, inbox.ID); delErr != nil {
+				log.Printf("session %s: error removing chatwoot inbox %d: %v", session.SessionID.String(), inbox.ID, delErr)
+			}
+			return nil, fmt.Errorf("assigning agents: %w", err)
+		}
+	}
+	return inbox, nil
+}
+
+type SessionUpdate struct {
+	Description       *string
+	ChatwootToken     *string
+	ChatwootAccountID *int
+	ChatwootInboxID   *int
+	Settings          *domain.SessionSettings
+}
+
+// UpdateSession applies the set fields of u. Changed Chatwoot settings are
+// checked against the Chatwoot API before saving, and a working token
+// clears the invalid token flag.
+func (s *SessionService) UpdateSession(session db.CodechatSession, u SessionUpdate) (*db.CodechatSession, error) {
+	updated := session
+	if u.Description != nil {
+		updated.Description = *u.Description
+	}
+	if u.ChatwootToken != nil {
+		updated.ChatwootToken = *u.ChatwootToken
+	}
+	if u.ChatwootAccountID != nil {
+		updated.ChatwootAccountID = int32(*u.ChatwootAccountID)
+	}
+	if u.ChatwootInboxID != nil {
+		updated.ChatwootInboxID = int32(*u.ChatwootInboxID)
+	}
+	if u.Settings != nil {
+		if err := u.Settings.Validate(); err != nil {
+			return nil, err
+		}
+		updated.Settings = *u.Settings
+	}
+
+	credentialsChanged := u.ChatwootToken != nil || u.ChatwootAccountID != nil || u.ChatwootInboxID != nil
+	if credentialsChanged {
+		if err := s.validateChatwoot(updated); err != nil {
+			return nil, err
+		}
+	}
+
+	err := s.db.UpdateSession(*s.ctx, db.UpdateSessionParams{
+		ID:                     updated.ID,
+		SessionID:              updated.SessionID,
+		CodechatInstance:       updated.CodechatInstance,
+		CodechatInstcanceToken: updated.CodechatInstcanceToken,
+		ChatwootToken:          updated.ChatwootToken,
+		ChatwootAccountID:      updated.ChatwootAccountID,
+		ChatwootInboxID:        updated.ChatwootInboxID,
+		Description:            updated.Description,
+		Settings:               updated.Settings,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The flag is only touched when the credentials change, a relay may
+	// have set it meanwhile
+	if credentialsChanged {
+		if err := s.db.SetChatwootTokenInvalid(*s.ctx, db.SetChatwootTokenInvalidParams{
+			SessionID:            updated.SessionID,
+			ChatwootTokenInvalid: false,
+		}); err != nil {
+			return nil, err
+		}
+		updated.ChatwootTokenInvalid = false
+	}
+	return &updated, nil
+}
+
+func (s *SessionService) validateChatwoot(session db.CodechatSession) error {
+	if session.ChatwootToken == "" {
+		return fmt.Errorf("chatwoot token is required")
+	}
+	if session.ChatwootAccountID <= 0 || session.ChatwootInboxID <= 0 {
+		return fmt.Errorf("chatwoot account_id and inbox_id are required")
+	}
+	client, err := chatwoot.New(s.cfg.Chatwoot.URL, session.ChatwootToken, int(session.ChatwootAccountID))
+	if err != nil {
+		return err
+	}
+	if _, err := client.GetInbox(*s.ctx, int(session.ChatwootInboxID)); err != nil {
+		switch {
+		case chatwoot.IsUnauthorized(err):
+			return fmt.Errorf("chatwoot rejected the token for account %d: %w", session.ChatwootAccountID, err)
+		case chatwoot.IsNotFound(err):
+			return fmt.Errorf("chatwoot inbox %d not found in account %d: %w", session.ChatwootInboxID, session.ChatwootAccountID, err)
+		}
+		return fmt.Errorf("checking chatwoot inbox: %w", err)
+	}
+	return nil
 }
 
 func (s *SessionService) ConnectSession() (*string, error) {
@@ -108,22 +407,88 @@ func (s *SessionService) ConnectSession() (*string, error) {
 	return &i.Base64, nil
 }
 
-func (s *SessionService) SetWebhook(instance string) error {
-	u, err := url.Parse(s.cfg.Server.URL)
+// PairSession connects the instance through a pairing code for the phone,
+// for customers that can't scan a QR code shown on the same phone.
+func (s *SessionService) PairSession(phone string) (string, error) {
+	number, err := utils.ValidatePhone(phone)
+	if err != nil {
+		return "", err
+	}
+	// International numbers are validated with their leading +, Codechat
+	// only takes the digits
+	i, err := s.client.PairInstance(*s.ctx, utils.PhoneDigits(number))
+	if err != nil {
+		return "", err
+	}
+	if i.PairingCode == "" {
+		return "", fmt.Errorf("codechat returned no pairing code, the session may already be connected")
+	}
+	return i.PairingCode, nil
+}
+
+// SendText sends text to phone through the instance, returning the key ID
+// of the message.
+func (s *SessionService) SendText(phone, text string) (string, error) {
+	number, err := utils.ValidatePhone(phone)
+	if err != nil {
+		return "", err
+	}
+	sent, err := s.client.SendText(*s.ctx, codechat.SendTextParams{
+		Number:      utils.PhoneDigits(number),
+		TextMessage: codechat.CCTextMessage{Text: text},
+	})
+	if err != nil {
+		return "", err
+	}
+	return sent.Key.ID, nil
+}
+
+func (s *SessionService) SetWebhook(session db.CodechatSession) error {
+	u, err := CodechatWebhookURL(s.cfg, session)
 	if err != nil {
 		return err
 	}
-	u.Path = path.Join(u.Path, "/codechat/webhook/", instance)
-	_, err = s.client.SetWebhook(*s.ctx, codechat.NewSetWebhookParams(u.String()))
+	_, err = s.client.SetWebhook(*s.ctx, codechat.NewSetWebhookParams(u))
 	return err
 }
 
+// RestartSession restarts the instance, for when it's stuck connecting.
+// The webhook is set again so the session keeps relaying afterwards.
+func (s *SessionService) RestartSession(session db.CodechatSession) error {
+	if _, err := s.client.RestartInstance(*s.ctx); err != nil {
+		return err
+	}
+	return s.SetWebhook(session)
+}
+
+// LogoutSession unlinks WhatsApp from the instance but keeps the instance
+// and the session, so a new QR code can be scanned.
+func (s *SessionService) LogoutSession(session db.CodechatSession) error {
+	if _, err := s.client.LogoutInstance(*s.ctx); err != nil {
+		return err
+	}
+	return s.SetWebhook(session)
+}
+
 func (s *SessionService) FetchInstance() (*codechat.FetchInstanceResponse, error) {
 	r, err := s.client.FetchInstance(*s.ctx)
 	return r, err
 }
 
-func (s *SessionService) DeleteInstance() error {
+// DeleteInstance logs out and deletes the instance of a session being
+// deleted. Imported instances weren't created by the service, only their
+// webhook is switched off.
+func (s *SessionService) DeleteInstance(session db.CodechatSession) error {
+	if session.Imported {
+		u, err := CodechatWebhookURL(s.cfg, session)
+		if err != nil {
+			return err
+		}
+		params := codechat.NewSetWebhookParams(u)
+		params.Enabled = false
+		_, err = s.client.SetWebhook(*s.ctx, params)
+		return err
+	}
 	_, err := s.client.LogoutInstance(*s.ctx)
 	if err != nil {
 		return err
@@ -131,3 +496,175 @@ func (s *SessionService) DeleteInstance() error {
 	_, err = s.client.DeleteInstance(*s.ctx)
 	return err
 }
+
+const (
+	// Live statuses are fetched from Codechat at most this many at a time
+	listStatusConcurrency = 8
+	listStatusTimeout     = 10 * time.Second
+	// Filtering by status reads the sessions in batches of this size, and
+	// gives up after looking at maxStatusFilterSessions of them
+	statusFilterBatch       = 50
+	maxStatusFilterSessions = 500
+)
+
+// ErrStatusFilterTooBroad is returned when filtering by status would need
+// the live status of too many sessions.
+var ErrStatusFilterTooBroad = fmt.Errorf("status filter matches too few of the first %d sessions, narrow it with account_id or inbox_id", maxStatusFilterSessions)
+
+// SessionFilter narrows ListSessions, zero values don't filter.
+type SessionFilter struct {
+	ChatwootAccountID int32
+	ChatwootInboxID   int32
+	// Codechat connection status, e.g. ONLINE. Implies Live.
+	Status string
+	// Fetch the connection status of each session from Codechat
+	Live bool
+	// Only sessions within the key scope
+	Principal *auth.Principal
+
+	Limit  int32
+	Offset int32
+}
+
+type SessionListItem struct {
+	Session   db.CodechatSession
+	Status    string
+	StatusErr error
+	Broken    error
+}
+
+// ListSessions returns a page of sessions and whether more follow. Filtering
+// by status needs the live status of the sessions before the page can be
+// cut, they're read in batches until the page is full, up to
+// maxStatusFilterSessions.
+func (s *SessionService) ListSessions(f SessionFilter) ([]SessionListItem, bool, error) {
+	params := db.ListSessionsFilteredParams{}
+	if f.ChatwootAccountID != 0 {
+		params.ChatwootAccountID = pgtype.Int4{Int32: f.ChatwootAccountID, Valid: true}
+	}
+	if f.ChatwootInboxID != 0 {
+		params.ChatwootInboxID = pgtype.Int4{Int32: f.ChatwootInboxID, Valid: true}
+	}
+	if f.Principal != nil && !f.Principal.Admin && (len(f.Principal.SessionIDs) > 0 || len(f.Principal.AccountIDs) > 0) {
+		params.Scoped = true
+		params.ScopeAccountIds = f.Principal.AccountIDs
+		for _, id := range f.Principal.SessionIDs {
+			var u pgtype.UUID
+			if err := u.Scan(id); err == nil {
+				params.ScopeSessionIds = append(params.ScopeSessionIds, u)
+			}
+		}
+	}
+	if params.ScopeSessionIds == nil {
+		params.ScopeSessionIds = []pgtype.UUID{}
+	}
+	if params.ScopeAccountIds == nil {
+		params.ScopeAccountIds = []int32{}
+	}
+	if f.Status != "" {
+		return s.listSessionsByStatus(f, params)
+	}
+
+	// One extra row tells whether there is a next page
+	params.Limit = pgtype.Int4{Int32: f.Limit + 1, Valid: true}
+	params.Offset = f.Offset
+	sessions, broken, err := s.db.ListSessionsFiltered(*s.ctx, params)
+	if err != nil {
+		return nil, false, err
+	}
+	items := newSessionListItems(sessions, broken)
+	hasMore := len(items) > int(f.Limit)
+	if hasMore {
+		items = items[:f.Limit]
+	}
+	if f.Live {
+		s.fetchStatuses(items)
+	}
+	return items, hasMore, nil
+}
+
+func (s *SessionService) listSessionsByStatus(f SessionFilter, params db.ListSessionsFilteredParams) ([]SessionListItem, bool, error) {
+	// One extra match tells whether there is a next page
+	want := int(f.Offset) + int(f.Limit) + 1
+	var matching []SessionListItem
+	params.Limit = pgtype.Int4{Int32: statusFilterBatch, Valid: true}
+	for params.Offset = 0; len(matching) < want; params.Offset += statusFilterBatch {
+		if params.Offset >= maxStatusFilterSessions {
+			return nil, false, ErrStatusFilterTooBroad
+		}
+		sessions, broken, err := s.db.ListSessionsFiltered(*s.ctx, params)
+		if err != nil {
+			return nil, false, err
+		}
+		items := newSessionListItems(sessions, broken)
+		s.fetchStatuses(items)
+		for _, item := range items {
+			if strings.EqualFold(item.Status, f.Status) {
+				matching = append(matching, item)
+			}
+		}
+		if len(sessions) < statusFilterBatch {
+			break
+		}
+	}
+
+	if int(f.Offset) >= len(matching) {
+		return []SessionListItem{}, false, nil
+	}
+	matching = matching[f.Offset:]
+	hasMore := len(matching) > int(f.Limit)
+	if hasMore {
+		matching = matching[:f.Limit]
+	}
+	return matching, hasMore, nil
+}
+
+// newSessionListItems marks the sessions in broken, those whose tokens
+// couldn't be decrypted.
+func newSessionListItems(sessions []db.CodechatSession, broken map[string]error) []SessionListItem {
+	items := make([]SessionListItem, len(sessions))
+	for i, session := range sessions {
+		items[i].Session = session
+		if err, ok := broken[session.SessionID.String()]; ok {
+			items[i].Status = "broken"
+			items[i].Broken = err
+		}
+	}
+	return items
+}
+
+func (s *SessionService) fetchStatuses(items []SessionListItem) {
+	sem := make(chan struct{}, listStatusConcurrency)
+	var wg sync.WaitGroup
+	for i := range items {
+		item := &items[i]
+		if item.Broken != nil {
+			continue
+		}
+		if err := ValidateSession(s.cfg, item.Session); err != nil {
+			item.Status = "broken"
+			item.Broken = err
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			item.Status, item.StatusErr = s.fetchStatus(item.Session)
+		}()
+	}
+	wg.Wait()
+}
+
+// fetchStatus asks Codechat for the connection status of the session,
+// through the clients cached in the registry.
+func (s *SessionService) fetchStatus(session db.CodechatSession) (string, error) {
+	ctx, cancel := context.WithTimeout(*s.ctx, listStatusTimeout)
+	defer cancel()
+	entry, err := s.registry.Get(ctx, session.SessionID.String())
+	if err != nil {
+		return "", err
+	}
+	return entry.Codechat.ConnectionStatus(ctx)
+}
@@ -0,0 +1,515 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sdrvirtual/codewoot/internal/codechat"
+	"github.com/sdrvirtual/codewoot/internal/config"
+	"github.com/sdrvirtual/codewoot/internal/db"
+	"github.com/sdrvirtual/codewoot/internal/domain"
+	"github.com/sdrvirtual/codewoot/internal/dto"
+)
+
+// newStatusTestService lists n sessions, instance i being ONLINE when
+// online(i), and counts the sessions read from the database.
+func newStatusTestService(t *testing.T, n int, online func(i int) bool) (*SessionService, *atomic.Int32) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/instance/fetchInstance/inst-"))
+		status := "OFFLINE"
+		if online(i) {
+			status = "ONLINE"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"name":"inst-%d","connectionStatus":%q}`, i, status)
+	}))
+	t.Cleanup(srv.Close)
+
+	sessions := make([]db.CodechatSession, n)
+	for i := range sessions {
+		sessions[i] = testSession(t, fmt.Sprintf("00000000-0000-4000-8000-%012d", i))
+		sessions[i].ID = int32(i)
+		sessions[i].CodechatInstance = fmt.Sprintf("inst-%d", i)
+	}
+	var read atomic.Int32
+	fdb := newFakeDB()
+	fdb.handle("GetSessionBySessionId", sessionsByID(sessions...))
+	fdb.handle("ListSessionsFiltered", func(args []any) ([][]any, error) {
+		limit, offset := int(args[5].(pgtype.Int4).Int32), int(args[6].(int32))
+		var rows [][]any
+		for _, s := range sessions[min(offset, n):min(offset+limit, n)] {
+			rows = append(rows, sessionRow(s))
+		}
+		read.Add(int32(len(rows)))
+		return rows, nil
+	})
+
+	cfg := &config.Config{}
+	cfg.Codechat.URL = srv.URL
+	cfg.Chatwoot.URL = "http://chatwoot.invalid"
+	ctx := context.Background()
+	return &SessionService{
+		cfg:      cfg,
+		ctx:      &ctx,
+		db:       db.NewStore(fdb, nil),
+		registry: newTestRegistry(cfg, fdb),
+	}, &read
+}
+
+func TestListSessions_StatusFilter(t *testing.T) {
+	s, read := newStatusTestService(t, 300, func(i int) bool { return i%2 == 0 })
+
+	items, hasMore, err := s.ListSessions(SessionFilter{Status: "online", Limit: 10, Offset: 20})
+	if err != nil {
+		t.Fatalf("ListSessions() error: %v", err)
+	}
+	if !hasMore {
+		t.Error("hasMore = false, want true")
+	}
+	if len(items) != 10 || items[0].Session.ID != 40 || items[9].Session.ID != 58 {
+		t.Fatalf("got %d sessions from %d to %d, want 10 from 40 to 58", len(items), items[0].Session.ID, items[len(items)-1].Session.ID)
+	}
+	// 31 matches fill the page and tell there's a next one, they're
+	// within the first two batches
+	if got := read.Load(); got != 2*statusFilterBatch {
+		t.Fatalf("read %d sessions, want %d", got, 2*statusFilterBatch)
+	}
+}
+
+func TestListSessions_StatusFilterLastPage(t *testing.T) {
+	s, _ := newStatusTestService(t, 70, func(i int) bool { return i%10 == 0 })
+
+	items, hasMore, err := s.ListSessions(SessionFilter{Status: "ONLINE", Limit: 5, Offset: 5})
+	if err != nil {
+		t.Fatalf("ListSessions() error: %v", err)
+	}
+	if hasMore || len(items) != 2 || items[0].Session.ID != 50 || items[1].Session.ID != 60 {
+		t.Fatalf("got %d sessions, hasMore %v, want sessions 50 and 60", len(items), hasMore)
+	}
+}
+
+func TestListSessions_StatusFilterTooBroad(t *testing.T) {
+	s, read := newStatusTestService(t, 2*maxStatusFilterSessions, func(i int) bool { return false })
+
+	_, _, err := s.ListSessions(SessionFilter{Status: "ONLINE", Limit: 10})
+	if !errors.Is(err, ErrStatusFilterTooBroad) {
+		t.Fatalf("ListSessions() error = %v, want ErrStatusFilterTooBroad", err)
+	}
+	if got := read.Load(); got != maxStatusFilterSessions {
+		t.Fatalf("read %d sessions, want %d", got, maxStatusFilterSessions)
+	}
+}
+
+func TestListSessions_Undecryptable(t *testing.T) {
+	ok := testSession(t, "00000000-0000-4000-8000-000000000001")
+	bad := testSession(t, "00000000-0000-4000-8000-000000000002")
+	bad.ChatwootToken = "enc:v1:retired:c2VhbGVk"
+	fdb := newFakeDB()
+	fdb.handle("ListSessionsFiltered", func(args []any) ([][]any, error) {
+		return [][]any{sessionRow(ok), sessionRow(bad)}, nil
+	})
+	ctx := context.Background()
+	s := &SessionService{cfg: &config.Config{}, ctx: &ctx, db: db.NewStore(fdb, nil)}
+
+	items, _, err := s.ListSessions(SessionFilter{Limit: 10})
+	if err != nil {
+		t.Fatalf("ListSessions() error: %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(items))
+	}
+	if items[0].Broken != nil {
+		t.Fatalf("session 1 broken: %v", items[0].Broken)
+	}
+	if items[1].Broken == nil || items[1].Status != "broken" {
+		t.Fatalf("session 2 = %+v, want broken", items[1])
+	}
+	if items[1].Session.ChatwootToken != "" {
+		t.Fatalf("session 2 kept token %q", items[1].Session.ChatwootToken)
+	}
+}
+
+func TestPairSession_Digits(t *testing.T) {
+	tests := []struct {
+		phone string
+		want  string
+	}{
+		{"+44 7700 900123", "447700900123"},
+		{"+1 (415) 555-0123", "14155550123"},
+		{"+55 (11) 98877-6655", "5511988776655"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.phone, func(t *testing.T) {
+			cc := newFakeAPI()
+			cc.reply("GET /instance/connect/inst", http.StatusOK, `{"pairingCode":"WZYEH1YY"}`)
+			srv := httptest.NewServer(cc)
+			t.Cleanup(srv.Close)
+			client, err := codechat.New(srv.URL, "global", codechat.WithInstanceToken("tok", "inst"))
+			if err != nil {
+				t.Fatalf("codechat.New() error: %v", err)
+			}
+			ctx := context.Background()
+			s := &SessionService{cfg: &config.Config{}, client: client, ctx: &ctx}
+
+			code, err := s.PairSession(tt.phone)
+			if err != nil {
+				t.Fatalf("PairSession() error: %v", err)
+			}
+			if code != "WZYEH1YY" {
+				t.Fatalf("PairSession() = %q", code)
+			}
+			reqs := cc.got("GET /instance/connect/inst")
+			if len(reqs) != 1 || reqs[0].Query.Get("number") != tt.want {
+				t.Fatalf("paired with %v, want number %s", reqs, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendText(t *testing.T) {
+	cc := newFakeAPI()
+	cc.reply("POST /message/sendText/inst", http.StatusCreated, `{"key":{"remoteJid":"447700900123@s.whatsapp.net","fromMe":true,"id":"3EB0C767D26A1D"}}`)
+	srv := httptest.NewServer(cc)
+	t.Cleanup(srv.Close)
+	client, err := codechat.New(srv.URL, "global", codechat.WithInstanceToken("tok", "inst"))
+	if err != nil {
+		t.Fatalf("codechat.New() error: %v", err)
+	}
+	ctx := context.Background()
+	s := &SessionService{cfg: &config.Config{}, client: client, ctx: &ctx}
+
+	id, err := s.SendText("+44 7700 900123", "Hello")
+	if err != nil {
+		t.Fatalf("SendText() error: %v", err)
+	}
+	if id != "3EB0C767D26A1D" {
+		t.Fatalf("SendText() = %q", id)
+	}
+	reqs := cc.got("POST /message/sendText/inst")
+	want := `{"number":"447700900123","textMessage":{"text":"Hello"}}`
+	if len(reqs) != 1 || strings.TrimSpace(reqs[0].Body) != want {
+		t.Fatalf("requests %v, want body %s", reqs, want)
+	}
+}
+
+func TestDeleteInstance(t *testing.T) {
+	tests := []struct {
+		name         string
+		imported     bool
+		wantRequests []string
+	}{
+		{"created", false, []string{"DELETE /instance/logout/inst", "DELETE /instance/delete/inst"}},
+		{"imported", true, []string{"PUT /webhook/set/inst"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := &fakeCodechat{webhookStatus: http.StatusOK}
+			srv := httptest.NewServer(cc)
+			t.Cleanup(srv.Close)
+			cfg := &config.Config{}
+			cfg.Server.URL = "https://bridge.example.com"
+			client, err := codechat.New(srv.URL, "global", codechat.WithInstanceToken("tok", "inst"))
+			if err != nil {
+				t.Fatalf("codechat.New() error: %v", err)
+			}
+			ctx := context.Background()
+			s := &SessionService{cfg: cfg, client: client, ctx: &ctx}
+
+			session := testSession(t, "b1f2f0f4-7930-4e90-9b6f-8c0f3c9b6c12")
+			session.Imported = tt.imported
+			if err := s.DeleteInstance(session); err != nil {
+				t.Fatalf("DeleteInstance() error: %v", err)
+			}
+			if got := cc.got(); !slices.Equal(got, tt.wantRequests) {
+				t.Fatalf("codechat requests = %v, want %v", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestUpdateSession_TokenFlag(t *testing.T) {
+	token := "new-token"
+	description := "Support"
+	tests := []struct {
+		name      string
+		update    SessionUpdate
+		wantExecs []string
+	}{
+		// A flag set by a relay meanwhile isn't overwritten
+		{"description", SessionUpdate{Description: &description}, []string{"UpdateSession"}},
+		{"token", SessionUpdate{ChatwootToken: &token}, []string{"UpdateSession", "SetChatwootTokenInvalid"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cw := newFakeAPI()
+			cw.reply("GET /api/v1/accounts/1/inboxes/7", http.StatusOK, `{"id":7,"channel_type":"Channel::Api"}`)
+			srv := httptest.NewServer(cw)
+			t.Cleanup(srv.Close)
+			fdb := newFakeDB()
+			cfg := &config.Config{}
+			cfg.Chatwoot.URL = srv.URL
+			ctx := context.Background()
+			s := &SessionService{cfg: cfg, ctx: &ctx, db: db.NewStore(fdb, nil)}
+
+			session := testSession(t, "b1f2f0f4-7930-4e90-9b6f-8c0f3c9b6c12")
+			session.ChatwootTokenInvalid = true
+			updated, err := s.UpdateSession(session, tt.update)
+			if err != nil {
+				t.Fatalf("UpdateSession() error: %v", err)
+			}
+			if got := fdb.executed(); !slices.Equal(got, tt.wantExecs) {
+				t.Fatalf("executed = %v, want %v", got, tt.wantExecs)
+			}
+			if wantInvalid := tt.update.ChatwootToken == nil; updated.ChatwootTokenInvalid != wantInvalid {
+				t.Fatalf("ChatwootTokenInvalid = %v, want %v", updated.ChatwootTokenInvalid, wantInvalid)
+			}
+		})
+	}
+}
+
+// fakeCodechat serves the instance endpoints CreateSession uses, answering
+// the webhook with webhookStatus, and records the requests it got.
+type fakeCodechat struct {
+	webhookStatus int
+	mu            sync.Mutex
+	requests      []string
+}
+
+func (f *fakeCodechat) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == "/instance/create":
+		_, _ = w.Write([]byte(`{"name":"new-inst","Auth":{"token":"inst-token"}}`))
+	case strings.HasPrefix(r.URL.Path, "/instance/fetchInstance/"):
+		_, _ = w.Write([]byte(`{"name":"old-inst","connectionStatus":"ONLINE"}`))
+	case strings.HasPrefix(r.URL.Path, "/webhook/set/"):
+		w.WriteHeader(f.webhookStatus)
+		_, _ = w.Write([]byte(`{}`))
+	default:
+		_, _ = w.Write([]byte(`{}`))
+	}
+}
+
+func (f *fakeCodechat) got() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+func newCreateTestService(t *testing.T, cc *fakeCodechat, fdb *fakeDB) *SessionService {
+	t.Helper()
+	srv := httptest.NewServer(cc)
+	t.Cleanup(srv.Close)
+
+	cfg := &config.Config{}
+	cfg.Server.URL = "https://bridge.example.com"
+	cfg.Codechat.URL = srv.URL
+	cfg.Chatwoot.URL = "http://chatwoot.invalid"
+	client, err := codechat.New(cfg.Codechat.URL, "global")
+	if err != nil {
+		t.Fatalf("codechat.New() error: %v", err)
+	}
+	ctx := context.Background()
+	return &SessionService{cfg: cfg, client: client, ctx: &ctx, db: db.NewStore(fdb, nil)}
+}
+
+// storeCreatedSessions serves CreateSession with the row it was given.
+func storeCreatedSessions(fdb *fakeDB, err error) {
+	fdb.handle("CreateSession", func(args []any) ([][]any, error) {
+		if err != nil {
+			return nil, err
+		}
+		return [][]any{sessionRow(db.CodechatSession{
+			ID:                     1,
+			SessionID:              args[0].(pgtype.UUID),
+			CodechatInstance:       args[1].(string),
+			CodechatInstcanceToken: args[2].(string),
+			ChatwootToken:          args[3].(string),
+			ChatwootAccountID:      args[4].(int32),
+			ChatwootInboxID:        args[5].(int32),
+			WebhookSecret:          args[6].(string),
+			ChatwootHmacSecret:     args[7].(pgtype.Text),
+			Description:            args[8].(string),
+			Settings:               args[9].(domain.SessionSettings),
+			Imported:               args[10].(bool),
+		})}, nil
+	})
+}
+
+func createPayload() dto.CreateSession {
+	var payload dto.CreateSession
+	payload.Chatwoot.AccountID = 1
+	payload.Chatwoot.InboxID = 7
+	payload.Chatwoot.Token = "cw-token"
+	return payload
+}
+
+func TestCreateSession_RollsBack(t *testing.T) {
+	tests := []struct {
+		name          string
+		webhookStatus int
+		insertErr     error
+		wantRequests  []string
+		wantExecs     []string
+	}{
+		{
+			name:          "insert fails",
+			webhookStatus: http.StatusOK,
+			insertErr:     errors.New("insert failed"),
+			wantRequests:  []string{"POST /instance/create", "DELETE /instance/delete/new-inst"},
+		},
+		{
+			name:          "webhook fails",
+			webhookStatus: http.StatusInternalServerError,
+			wantRequests:  []string{"POST /instance/create", "PUT /webhook/set/new-inst", "DELETE /instance/delete/new-inst"},
+			wantExecs:     []string{"DeleteSessionBySessionId"},
+		},
+		{
+			name:          "created",
+			webhookStatus: http.StatusOK,
+			wantRequests:  []string{"POST /instance/create", "PUT /webhook/set/new-inst"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := &fakeCodechat{webhookStatus: tt.webhookStatus}
+			fdb := newFakeDB()
+			fdb.handle("GetSessionBySessionId", sessionsByID())
+			storeCreatedSessions(fdb, tt.insertErr)
+			s := newCreateTestService(t, cc, fdb)
+
+			session, err := s.CreateSession(createPayload())
+			if wantErr := tt.insertErr != nil || tt.webhookStatus != http.StatusOK; (err != nil) != wantErr {
+				t.Fatalf("CreateSession() error = %v, wantErr %v", err, wantErr)
+			}
+			if err == nil && session.CodechatInstance != "new-inst" {
+				t.Fatalf("session instance = %q, want new-inst", session.CodechatInstance)
+			}
+			if got := cc.got(); !slices.Equal(got, tt.wantRequests) {
+				t.Fatalf("codechat requests = %v, want %v", got, tt.wantRequests)
+			}
+			if got := fdb.executed(); !slices.Equal(got, tt.wantExecs) {
+				t.Fatalf("executed = %v, want %v", got, tt.wantExecs)
+			}
+		})
+	}
+}
+
+func TestCreateSession_RollsBackAfterCancel(t *testing.T) {
+	cc := &fakeCodechat{webhookStatus: http.StatusOK}
+	fdb := newFakeDB()
+	fdb.handle("GetSessionBySessionId", sessionsByID())
+	s := newCreateTestService(t, cc, fdb)
+	ctx, cancel := context.WithCancel(context.Background())
+	s.ctx = &ctx
+	// The client goes away while the row is inserted
+	fdb.handle("CreateSession", func(args []any) ([][]any, error) {
+		cancel()
+		return nil, context.Canceled
+	})
+
+	if _, err := s.CreateSession(createPayload()); err == nil {
+		t.Fatal("CreateSession() error = nil")
+	}
+	want := []string{"POST /instance/create", "DELETE /instance/delete/new-inst"}
+	if got := cc.got(); !slices.Equal(got, want) {
+		t.Fatalf("codechat requests = %v, want %v", got, want)
+	}
+}
+
+func TestCreateSession_Import(t *testing.T) {
+	tests := []struct {
+		name          string
+		taken         bool
+		webhookStatus int
+		insertErr     error
+		wantErr       error
+		wantRequests  []string
+		wantExecs     []string
+	}{
+		{
+			name:          "imported",
+			webhookStatus: http.StatusOK,
+			wantRequests:  []string{"GET /instance/fetchInstance/old-inst", "PUT /webhook/set/old-inst"},
+		},
+		{
+			name:          "taken",
+			taken:         true,
+			webhookStatus: http.StatusOK,
+			wantErr:       ErrInstanceTaken,
+		},
+		{
+			name:          "taken meanwhile",
+			webhookStatus: http.StatusOK,
+			insertErr:     &pgconn.PgError{Code: "23505", ConstraintName: instanceUniqueIndex},
+			wantErr:       ErrInstanceTaken,
+			wantRequests:  []string{"GET /instance/fetchInstance/old-inst"},
+		},
+		{
+			// The adopted instance is left as it was
+			name:          "webhook fails",
+			webhookStatus: http.StatusInternalServerError,
+			wantRequests:  []string{"GET /instance/fetchInstance/old-inst", "PUT /webhook/set/old-inst"},
+			wantExecs:     []string{"DeleteSessionBySessionId"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := &fakeCodechat{webhookStatus: tt.webhookStatus}
+			fdb := newFakeDB()
+			fdb.handle("GetSessionBySessionId", sessionsByID())
+			fdb.handle("InstanceHasSession", func(args []any) ([][]any, error) {
+				if args[0] != "old-inst" {
+					t.Fatalf("looked up instance %v, want old-inst", args[0])
+				}
+				return [][]any{{tt.taken}}, nil
+			})
+			storeCreatedSessions(fdb, tt.insertErr)
+			s := newCreateTestService(t, cc, fdb)
+
+			payload := createPayload()
+			payload.Codechat = &dto.ImportInstance{Instance: "old-inst", Token: "old-token"}
+			session, err := s.CreateSession(payload)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("CreateSession() error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.webhookStatus != http.StatusOK:
+				if err == nil {
+					t.Fatal("CreateSession() succeeded with the webhook failing")
+				}
+			case err != nil:
+				t.Fatalf("CreateSession() error: %v", err)
+			default:
+				if session.CodechatInstance != "old-inst" || session.CodechatInstcanceToken != "old-token" {
+					t.Fatalf("session instance = %q token %q, want the imported one", session.CodechatInstance, session.CodechatInstcanceToken)
+				}
+				if !session.Imported {
+					t.Fatal("session not marked imported")
+				}
+			}
+			if got := cc.got(); !slices.Equal(got, tt.wantRequests) {
+				t.Fatalf("codechat requests = %v, want %v", got, tt.wantRequests)
+			}
+			if got := fdb.executed(); !slices.Equal(got, tt.wantExecs) {
+				t.Fatalf("executed = %v, want %v", got, tt.wantExecs)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sdrvirtual/codewoot/internal/config"
+	"github.com/sdrvirtual/codewoot/internal/db"
+)
+
+// WebhookSecretParam is the query parameter carrying the session's webhook
+// secret in the URLs handed to Codechat and Chatwoot.
+const WebhookSecretParam = "secret"
+
+// chatwootSignatureTolerance is how old a signed Chatwoot webhook may be
+// before it's treated as a replay.
+const chatwootSignatureTolerance = 5 * time.Minute
+
+var (
+	ErrInvalidWebhookSecret = errors.New("invalid webhook secret")
+	ErrInvalidSignature     = errors.New("invalid webhook signature")
+)
+
+func NewWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func CodechatWebhookURL(cfg *config.Config, session db.CodechatSession) (string, error) {
+	return webhookURL(cfg, "/codechat/webhook/", session)
+}
+
+func ChatwootWebhookURL(cfg *config.Config, session db.CodechatSession) (string, error) {
+	return webhookURL(cfg, "/chatwoot/webhook/", session)
+}
+
+func webhookURL(cfg *config.Config, prefix string, session db.CodechatSession) (string, error) {
+	u, err := url.Parse(cfg.Server.URL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(u.Path, prefix, session.SessionID.String())
+	q := u.Query()
+	q.Set(WebhookSecretParam, session.WebhookSecret)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func VerifyWebhookSecret(session db.CodechatSession, secret string) error {
+	if session.WebhookSecret == "" || secret == "" {
+		return ErrInvalidWebhookSecret
+	}
+	if subtle.ConstantTimeCompare([]byte(session.WebhookSecret), []byte(secret)) != 1 {
+		return ErrInvalidWebhookSecret
+	}
+	return nil
+}
+
+// VerifyChatwootSignature checks Chatwoot's X-Chatwoot-Signature header,
+// an HMAC-SHA256 of "{timestamp}.{body}" in the form "sha256=<hex>". It's
+// only enforced for sessions that have a signing secret configured.
+func VerifyChatwootSignature(session db.CodechatSession, signature, timestamp string, body []byte, now time.Time) error {
+	if !session.ChatwootHmacSecret.Valid || session.ChatwootHmacSecret.String == "" {
+		return nil
+	}
+	if signature == "" || timestamp == "" {
+		return fmt.Errorf("%w: missing signature headers", ErrInvalidSignature)
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp", ErrInvalidSignature)
+	}
+	age := now.Sub(time.Unix(ts, 0))
+	if age > chatwootSignatureTolerance || age < -chatwootSignatureTolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrInvalidSignature)
+	}
+
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature", ErrInvalidSignature)
+	}
+	mac := hmac.New(sha256.New, []byte(session.ChatwootHmacSecret.String))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
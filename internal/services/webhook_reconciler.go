@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// WebhookReconciler puts back the Codechat webhook and the Chatwoot inbox
+// webhook of sessions edited by hand, still pointing at an old API_URL or
+// registered without their webhook secret, which would otherwise stop
+// receiving messages without notice.
+type WebhookReconciler struct {
+	registry *SessionRegistry
+	interval time.Duration
+}
+
+func NewWebhookReconciler(registry *SessionRegistry) *WebhookReconciler {
+	return &WebhookReconciler{
+		registry: registry,
+		interval: registry.cfg.Sessions.WebhookReconcileInterval,
+	}
+}
+
+// Run reconciles every session on startup and then on each interval until
+// the workers stop or ctx is done. The startup pass always runs, it's what re-registers the
+// webhooks after a migration changed their secret.
+func (w *WebhookReconciler) Run(ctx context.Context) {
+	w.ReconcileAll(ctx)
+	if w.interval <= 0 {
+		return
+	}
+
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.registry.workers.Stopping():
+			return
+		case <-t.C:
+			w.ReconcileAll(ctx)
+		}
+	}
+}
+
+// ReconcileAll checks the webhook of every session and returns how many
+// were fixed. Failures are logged and don't stop the others.
+func (w *WebhookReconciler) ReconcileAll(ctx context.Context) int {
+	sessions, broken, err := w.registry.db.ListSessions(ctx)
+	if err != nil {
+		log.Printf("webhook reconciler: error listing sessions: %v", err)
+		return 0
+	}
+	fixed := 0
+	for _, s := range sessions {
+		if ctx.Err() != nil {
+			break
+		}
+		id := s.SessionID.String()
+		if err, ok := broken[id]; ok {
+			log.Printf("webhook reconciler: skipping session %s: %v", id, err)
+			continue
+		}
+		entry, err := w.registry.Get(ctx, id)
+		if err != nil {
+			log.Printf("webhook reconciler: session %s: %v", id, err)
+			continue
+		}
+		if w.reconcile(ctx, entry) {
+			fixed++
+		}
+	}
+	if fixed > 0 {
+		log.Printf("webhook reconciler: fixed %d of %d sessions", fixed, len(sessions))
+	}
+	return fixed
+}
+
+// reconcile fixes both webhooks of the session, reporting whether any of
+// them needed it.
+func (w *WebhookReconciler) reconcile(ctx context.Context, entry *SessionEntry) bool {
+	id := entry.Session.SessionID.String()
+	fixed := false
+
+	opCtx, cancel := context.WithTimeout(ctx, w.registry.cfg.Relay.Timeout)
+	drift, err := entry.Codechat.ReconcileWebhook(opCtx, entry.Session)
+	cancel()
+	if err != nil {
+		log.Printf("webhook reconciler: session %s: %v", id, err)
+	} else if drift != "" {
+		fixed = true
+		log.Printf("webhook reconciler: session %s: fixed webhook of instance %s: %s", id, entry.Session.CodechatInstance, drift)
+	}
+
+	drift, err = entry.Chatwoot.ReconcileWebhook(ctx)
+	if err != nil {
+		log.Printf("webhook reconciler: session %s: %v", id, err)
+	} else if drift != "" {
+		fixed = true
+		log.Printf("webhook reconciler: session %s: fixed webhook of inbox %d: %s", id, entry.Session.ChatwootInboxID, drift)
+	}
+	return fixed
+}
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/sdrvirtual/codewoot/internal/codechat"
+)
+
+func TestWebhookDrift(t *testing.T) {
+	want := *codechat.NewSetWebhookParams("https://relay.example.com/codechat/webhook/abc?secret=s")
+
+	disabled := want
+	disabled.Enabled = false
+	moved := want
+	moved.URL = "https://old.example.com/codechat/webhook/abc?secret=s"
+	noQR := want
+	noQR.Events.QrcodeUpdated = false
+	extra := want
+	extra.Events.ChatsSet = true
+
+	tests := []struct {
+		name string
+		got  codechat.SetWebhookParams
+		want string
+	}{
+		{"match", want, ""},
+		{"missing", codechat.SetWebhookParams{}, "no webhook set"},
+		{"url", moved, "webhook url changed"},
+		{"disabled", disabled, "webhook disabled"},
+		{"missing event", noQR, "webhook events changed"},
+		{"extra event", extra, "webhook events changed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := webhookDrift(want, tt.got); got != tt.want {
+				t.Fatalf("webhookDrift() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
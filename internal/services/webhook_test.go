@@ -0,0 +1,121 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/sdrvirtual/codewoot/internal/config"
+	"github.com/sdrvirtual/codewoot/internal/db"
+)
+
+func sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookURLIncludesSecret(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.URL = "https://bridge.example.com/base"
+
+	var id pgtype.UUID
+	if err := id.Scan("b1f2f0f4-7930-4e90-9b6f-8c0f3c9b6c12"); err != nil {
+		t.Fatalf("scan uuid: %v", err)
+	}
+	session := db.CodechatSession{SessionID: id, WebhookSecret: "s3cr3t"}
+
+	got, err := CodechatWebhookURL(cfg, session)
+	if err != nil {
+		t.Fatalf("CodechatWebhookURL error: %v", err)
+	}
+	want := "https://bridge.example.com/base/codechat/webhook/b1f2f0f4-7930-4e90-9b6f-8c0f3c9b6c12?secret=s3cr3t"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestVerifyWebhookSecret(t *testing.T) {
+	session := db.CodechatSession{WebhookSecret: "s3cr3t"}
+	if err := VerifyWebhookSecret(session, "s3cr3t"); err != nil {
+		t.Fatalf("expected valid secret, got %v", err)
+	}
+	if err := VerifyWebhookSecret(session, "wrong"); !errors.Is(err, ErrInvalidWebhookSecret) {
+		t.Fatalf("expected ErrInvalidWebhookSecret, got %v", err)
+	}
+	if err := VerifyWebhookSecret(db.CodechatSession{}, ""); !errors.Is(err, ErrInvalidWebhookSecret) {
+		t.Fatalf("expected empty secrets to be rejected, got %v", err)
+	}
+}
+
+func TestVerifyChatwootSignature(t *testing.T) {
+	now := time.Unix(1764803328, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	body := []byte(`{"event":"message_created"}`)
+	session := db.CodechatSession{
+		ChatwootHmacSecret: pgtype.Text{String: "hmac-secret", Valid: true},
+	}
+
+	tests := []struct {
+		name      string
+		session   db.CodechatSession
+		signature string
+		timestamp string
+		now       time.Time
+		wantErr   bool
+	}{
+		{
+			name:    "no_secret_configured",
+			session: db.CodechatSession{},
+			now:     now,
+		},
+		{
+			name:      "valid",
+			session:   session,
+			signature: sign("hmac-secret", ts, body),
+			timestamp: ts,
+			now:       now,
+		},
+		{
+			name:      "missing_headers",
+			session:   session,
+			now:       now,
+			wantErr:   true,
+			timestamp: ts,
+		},
+		{
+			name:      "wrong_secret",
+			session:   session,
+			signature: sign("other", ts, body),
+			timestamp: ts,
+			now:       now,
+			wantErr:   true,
+		},
+		{
+			name:      "replayed",
+			session:   session,
+			signature: sign("hmac-secret", ts, body),
+			timestamp: ts,
+			now:       now.Add(10 * time.Minute),
+			wantErr:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := VerifyChatwootSignature(tc.session, tc.signature, tc.timestamp, body, tc.now)
+			if tc.wantErr && !errors.Is(err, ErrInvalidSignature) {
+				t.Fatalf("expected ErrInvalidSignature, got %v", err)
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
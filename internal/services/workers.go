@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"sync"
+)
+
+// Workers tracks background goroutines so they can be drained on shutdown.
+// Loops should return once Stopping is closed, work already running gets to
+// finish: the context handed to each worker is only cancelled when the
+// Shutdown deadline passes.
+type Workers struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func NewWorkers() *Workers {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Workers{ctx: ctx, cancel: cancel, stop: make(chan struct{})}
+}
+
+func (w *Workers) Go(fn func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		fn(w.ctx)
+	}()
+}
+
+// Stopping is closed when Shutdown starts, loops should stop picking up
+// new work then.
+func (w *Workers) Stopping() <-chan struct{} {
+	return w.stop
+}
+
+// Shutdown stops the loops and waits for the workers to return. When ctx is
+// done first, the workers are cancelled and ctx's error returned.
+func (w *Workers) Shutdown(ctx context.Context) error {
+	w.stopOnce.Do(func() { close(w.stop) })
+	defer w.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWorkers_ShutdownDrains(t *testing.T) {
+	w := NewWorkers()
+	started, release := make(chan struct{}), make(chan struct{})
+	var workErr error
+	w.Go(func(ctx context.Context) {
+		close(started)
+		<-release
+		// Work already running isn't cancelled by the shutdown
+		workErr = ctx.Err()
+	})
+	loopDone := make(chan struct{})
+	w.Go(func(ctx context.Context) {
+		<-w.Stopping()
+		close(loopDone)
+	})
+	<-started
+
+	shutdown := make(chan error)
+	go func() { shutdown <- w.Shutdown(context.Background()) }()
+	<-loopDone
+	close(release)
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown() error: %v", err)
+	}
+	if workErr != nil {
+		t.Fatalf("running work saw %v, want it to finish uncancelled", workErr)
+	}
+}
+
+func TestWorkers_ShutdownDeadline(t *testing.T) {
+	w := NewWorkers()
+	cancelled := make(chan struct{})
+	w.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		close(cancelled)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := w.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() error = %v, want the deadline", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("workers not cancelled once the deadline passed")
+	}
+}
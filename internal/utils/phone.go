@@ -104,3 +104,48 @@ func isDigits(s string) bool {
 	}
 	return true
 }
+
+// PhoneDigits keeps only the digits of phone, e.g. "+55 (11) 98877-6655"
+// becomes "5511988776655".
+func PhoneDigits(phone string) string {
+	var b strings.Builder
+	for i := 0; i < len(phone); i++ {
+		if phone[i] >= '0' && phone[i] <= '9' {
+			b.WriteByte(phone[i])
+		}
+	}
+	return b.String()
+}
+
+// SamePhone reports whether a and b are the same number. Brazilian mobiles
+// with and without the ninth digit are the same person, WhatsApp still
+// uses the old format for many of them.
+func SamePhone(a, b string) bool {
+	a, b = withoutNinthDigit(PhoneDigits(a)), withoutNinthDigit(PhoneDigits(b))
+	return a != "" && a == b
+}
+
+// PhoneForms returns the digits of phone and, for a Brazilian mobile, the
+// same number with or without the ninth digit, the forms SamePhone matches.
+func PhoneForms(phone string) []string {
+	digits := PhoneDigits(phone)
+	if digits == "" {
+		return nil
+	}
+	if short := withoutNinthDigit(digits); short != digits {
+		return []string{digits, short}
+	}
+	if len(digits) == 12 && strings.HasPrefix(digits, "55") && digits[4] >= '6' {
+		return []string{digits, digits[:4] + "9" + digits[4:]}
+	}
+	return []string{digits}
+}
+
+// withoutNinthDigit drops the ninth digit of a Brazilian mobile with
+// country code, mobiles are the numbers starting with 6 to 9.
+func withoutNinthDigit(digits string) string {
+	if len(digits) == 13 && strings.HasPrefix(digits, "55") && digits[4] == '9' && digits[5] >= '6' {
+		return digits[:4] + digits[5:]
+	}
+	return digits
+}
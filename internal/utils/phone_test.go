@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"slices"
 	"strings"
 	"testing"
 )
@@ -91,3 +92,43 @@ func TestValidatePhone(t *testing.T) {
 		})
 	}
 }
+
+func TestSamePhone(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"+55 11 98877-6655", "5511988776655", true},
+		{"+5511988776655", "551188776655", true},
+		{"551188776655", "+55 (11) 98877-6655", true},
+		{"+5511988776655", "+5511988776656", false},
+		// Landlines don't get a ninth digit
+		{"551133334444", "5511933334444", false},
+		{"+14155550123", "14155550123", true},
+		{"+14155550123", "4155550123", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got := SamePhone(tt.a, tt.b); got != tt.want {
+			t.Errorf("SamePhone(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestPhoneForms(t *testing.T) {
+	tests := []struct {
+		phone string
+		want  []string
+	}{
+		{"+55 11 98877-6655", []string{"5511988776655", "551188776655"}},
+		{"551188776655", []string{"551188776655", "5511988776655"}},
+		{"551133334444", []string{"551133334444"}},
+		{"+14155550123", []string{"14155550123"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := PhoneForms(tt.phone); !slices.Equal(got, tt.want) {
+			t.Errorf("PhoneForms(%q) = %v, want %v", tt.phone, got, tt.want)
+		}
+	}
+}